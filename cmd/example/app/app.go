@@ -4,10 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/dgraph-io/badger/v2"
 
@@ -28,12 +30,99 @@ func Run() (err error) {
 		return err
 	}
 
-	db, err := badger.Open(badger.DefaultOptions(cfg.DBPath).WithLogger(nil))
+	var store dedup.Store
+	switch cfg.Store {
+	case "badger":
+		db, openErr := badger.Open(badger.DefaultOptions(cfg.DBPath).WithLogger(nil))
+		if openErr != nil {
+			return openErr
+		}
+		defer func() {
+			if cerr := db.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}()
+		store = dedup.NewBadgerStore(db)
+	case "memory":
+		store = dedup.NewMemoryStore()
+	default:
+		return fmt.Errorf("unknown store %q", cfg.Store)
+	}
+
+	windowMode, err := dedup.ParseWindowMode(cfg.Tolerance.Window)
+	if err != nil {
+		return err
+	}
+
+	tierMode, err := dedup.ParseTierMode(cfg.Tolerance.TierMode)
+	if err != nil {
+		return err
+	}
+
+	tiers := make([]dedup.Tier, 0, len(cfg.Tolerance.Tiers))
+	for _, t := range cfg.Tolerance.Tiers {
+		tiers = append(tiers, dedup.Tier{Distance: t.Distance, Interval: t.Interval, Level: t.Level})
+	}
+
+	var zones []dedup.Zone
+	if cfg.Tolerance.ZonesFile != "" {
+		if zones, err = loadZones(cfg.Tolerance.ZonesFile); err != nil {
+			return err
+		}
+	}
+
+	var exclusions []dedup.Exclusion
+	if cfg.Tolerance.ExclusionsFile != "" {
+		if exclusions, err = loadExclusions(cfg.Tolerance.ExclusionsFile); err != nil {
+			return err
+		}
+	}
+
+	accuracyMode, err := dedup.ParseAccuracyMode(cfg.Accuracy.Mode)
+	if err != nil {
+		return err
+	}
+
+	policy, err := dedup.ParseLatenessPolicy(cfg.Lateness.Policy)
+	if err != nil {
+		return err
+	}
+
+	skewPolicy, err := dedup.ParseSkewPolicy(cfg.Skew.Policy)
 	if err != nil {
 		return err
 	}
 
-	filter, err := dedup.NewSpatioTemporalFilter(db, cfg.Tolerance.Distance, cfg.Tolerance.Interval)
+	speedPolicy, err := dedup.ParseSpeedPolicy(cfg.Speed.Policy)
+	if err != nil {
+		return err
+	}
+
+	// options shared by the default filter and the filters of the profiles.
+	shared := []dedup.Option{
+		dedup.WithMaxFutureSkew(cfg.Skew.MaxFuture, skewPolicy),
+		dedup.WithMaxSpeed(cfg.Speed.Max, speedPolicy),
+		dedup.WithConflictRetry(cfg.Retry.Attempts, cfg.Retry.Backoff),
+		dedup.WithLateEvents(func(ev dedup.Event) {
+			log.Printf("late event: entity=%q time=%s lat=%v lng=%v", ev.Entity, ev.Time.Format(time.RFC3339), ev.Lat, ev.Lng)
+		}),
+		dedup.WithDwell(cfg.Dwell.MinDuration, func(ev dedup.DwellEvent) {
+			log.Printf("dwell %s: entity=%q start=%s duration=%s lat=%v lng=%v", ev.Type, ev.Dwell.Entity,
+				ev.Dwell.Start.Format(time.RFC3339), ev.Dwell.Duration(), ev.Dwell.Center.Lat.Degrees(), ev.Dwell.Center.Lng.Degrees())
+		}),
+	}
+
+	filter, err := dedup.NewSpatioTemporalFilter(store, cfg.Tolerance.Distance, cfg.Tolerance.Interval, append([]dedup.Option{
+		dedup.WithWindow(windowMode),
+		dedup.WithTimeBuckets(cfg.Tolerance.Bucket),
+		dedup.WithTTL(cfg.TTL.Key, cfg.TTL.Grace),
+		dedup.WithEntityTTL(cfg.TTL.Entities),
+		dedup.WithAccuracy(accuracyMode, cfg.Accuracy.MinDistance, cfg.Accuracy.MaxDistance),
+		dedup.WithLateness(cfg.Lateness.Allowed, policy),
+		dedup.WithZones(zones...),
+		dedup.WithExclusions(exclusions...),
+		dedup.WithTiers(tierMode, tiers...),
+	}, shared...)...)
 	if err != nil {
 		return err
 	}
@@ -43,7 +132,54 @@ func Run() (err error) {
 		return fmt.Errorf("filter must be an instance of %T, got %T", (*dedup.SpatioTemporalFilter)(nil), filter)
 	}
 
-	srv, err := server.New(&cfg.Server, f)
+	registry, err := dedup.NewRegistry(store, f, shared...)
+	if err != nil {
+		return err
+	}
+
+	// started dwells are only ended by the sweeper, once entities are not
+	// seen any more, and keys in time buckets never expire on their own.
+	if cfg.Sweep.Interval <= 0 && cfg.Dwell.MinDuration > 0 {
+		return errors.New("sweeper is required by dwell detection")
+	}
+	if cfg.Sweep.Interval <= 0 && cfg.Tolerance.Bucket > 0 {
+		return errors.New("sweeper is required by time buckets")
+	}
+	if cfg.Sweep.Interval > 0 {
+		stop := registry.RunSweeper(cfg.Sweep.Interval, func(name string, stats dedup.SweepStats, err error) {
+			if err != nil {
+				log.Printf("sweep %q failed: %v", name, err)
+				return
+			}
+			log.Printf("sweep %q: deleted=%d dropped=%d ended=%d collected=%d duration=%s",
+				name, stats.Deleted, stats.Dropped, stats.Ended, stats.Collected, stats.Duration)
+		})
+		defer stop()
+	}
+
+	// profiles are created once the sweeper runs, which they may require.
+	for _, p := range cfg.Profiles {
+		_, err = registry.Create(dedup.Profile{
+			Name:           p.Name,
+			Distance:       p.Distance,
+			Interval:       p.Interval,
+			Window:         windowMode,
+			Bucket:         cfg.Tolerance.Bucket,
+			TTL:            cfg.TTL.Key,
+			Grace:          cfg.TTL.Grace,
+			EntityTTL:      cfg.TTL.Entities,
+			Accuracy:       accuracyMode,
+			MinDistance:    cfg.Accuracy.MinDistance,
+			MaxDistance:    cfg.Accuracy.MaxDistance,
+			Lateness:       cfg.Lateness.Allowed,
+			LatenessPolicy: policy,
+		}, true)
+		if err != nil {
+			return fmt.Errorf("profile %q: %w", p.Name, err)
+		}
+	}
+
+	srv, err := server.New(&cfg.Server, registry)
 	if err != nil {
 		return err
 	}
@@ -3,7 +3,17 @@ package config
 import "time"
 
 const (
-	defaultAddr = ":8080"
+	defaultAddr           = ":8080"
+	defaultStore          = "badger"
+	defaultWindowMode     = "tumbling"
+	defaultTierMode       = "all"
+	defaultAccuracyMode   = "ignore"
+	defaultLatenessPolicy = "accept"
+	defaultSkewPolicy     = "reject"
+	defaultSpeedPolicy    = "reject"
+	defaultSweepInterval  = 5 * time.Minute
+	defaultRetryAttempts  = 5
+	defaultRetryBackoff   = time.Millisecond
 )
 
 // Tolerance contains deduplication tolerance parameters.
@@ -13,6 +23,150 @@ type Tolerance struct {
 
 	// Interval is a time tolerance between location events.
 	Interval time.Duration
+
+	// Window is a mode how duplicates affect the time window of the location:
+	// "tumbling" or "sliding".
+	Window string
+
+	// Bucket is the size of time buckets, which location keys are partitioned
+	// by. It must be at least twice the time tolerance of any zone and tier.
+	// Expired buckets are dropped once per sweep of each tier and profile,
+	// which stalls writes of all the profiles until the drop is done. Zero
+	// keeps keys of each entity together.
+	Bucket time.Duration
+
+	// Tiers are additional tiers of distance and time tolerance.
+	Tiers []Tier
+
+	// TierMode is a mode how results of the tiers are combined: "all" or
+	// "any".
+	TierMode string
+
+	// ZonesFile is the path to GeoJSON FeatureCollection of polygons with
+	// their own distance and time tolerance in "distance" and "interval"
+	// properties.
+	ZonesFile string
+
+	// ExclusionsFile is the path to GeoJSON FeatureCollection of exclusion
+	// zones, where deduplication is bypassed. Zone is either a polygon or S2
+	// cell tokens in "cells" property, and is named by "name" property.
+	ExclusionsFile string
+}
+
+// Tier contains distance and time tolerance of an additional tier.
+type Tier struct {
+	// Distance is a distance tolerance between location events in meters.
+	Distance float64
+
+	// Interval is a time tolerance between location events.
+	Interval time.Duration
+
+	// Level is the cell level of the tier. Zero derives it from the distance
+	// tolerance.
+	Level int
+}
+
+// Profile contains distance and time tolerance of a named filter profile.
+// Other parameters are inherited from the default filter, except zones,
+// exclusions and tiers, which are only used by the default filter.
+type Profile struct {
+	// Name is the name of the profile.
+	Name string
+
+	// Distance is a distance tolerance between location events in meters.
+	Distance float64
+
+	// Interval is a time tolerance between location events.
+	Interval time.Duration
+}
+
+// TTL contains time to live parameters of the index entries.
+type TTL struct {
+	// Key is time to live of the index entries. Zero defaults to the time
+	// tolerance plus the allowed lateness and the grace margin.
+	Key time.Duration
+
+	// Grace is the margin added to the default time to live.
+	Grace time.Duration
+
+	// Entities is time to live of the index entries of the given entities,
+	// which overrides the default one. It must not be longer than the time to
+	// live of the filter and its profiles and is not supported with time
+	// buckets.
+	Entities map[string]time.Duration
+}
+
+// Accuracy contains parameters of the distance tolerance derived from
+// horizontal accuracy of events.
+type Accuracy struct {
+	// Mode is a mode to combine accuracy of two events: "ignore", "max" or
+	// "sum".
+	Mode string
+
+	// MinDistance is the lower bound of the distance tolerance in meters.
+	// Defaults to the distance tolerance.
+	MinDistance float64
+
+	// MaxDistance is the upper bound of the distance tolerance in meters.
+	// Defaults to the distance tolerance.
+	MaxDistance float64
+}
+
+// Lateness contains late events handling parameters.
+type Lateness struct {
+	// Allowed is the maximum lateness of events behind the most recent event.
+	// Defaults to the time tolerance.
+	Allowed time.Duration
+
+	// Policy is a policy for events, which are later than allowed: "accept",
+	// "reject" or "side-channel".
+	Policy string
+}
+
+// Skew contains future-dated events handling parameters.
+type Skew struct {
+	// MaxFuture is the maximum skew of event time ahead of the server time.
+	// Zero disables the check.
+	MaxFuture time.Duration
+
+	// Policy is a policy for events, which are dated further in the future:
+	// "reject" or "clamp".
+	Policy string
+}
+
+// Speed contains impossible speed handling parameters.
+type Speed struct {
+	// Max is the maximum speed of entities in meters per second. Zero disables
+	// the check.
+	Max float64
+
+	// Policy is a policy for events, which imply higher speed since the
+	// previous accepted event of the entity: "reject" or "flag".
+	Policy string
+}
+
+// Dwell contains dwell detection parameters.
+type Dwell struct {
+	// MinDuration is the minimum duration of the entity stay within the
+	// distance tolerance to be reported as a dwell. Zero disables detection.
+	MinDuration time.Duration
+}
+
+// Sweep contains background expiry sweeper parameters.
+type Sweep struct {
+	// Interval is the interval between sweeps of expired locations and store
+	// garbage collections. Zero disables the sweeper, which is required by
+	// dwell detection and time buckets.
+	Interval time.Duration
+}
+
+// Retry contains transaction conflict retry parameters.
+type Retry struct {
+	// Attempts is the maximum number of attempts to run a transaction.
+	Attempts int
+
+	// Backoff is the initial backoff between attempts.
+	Backoff time.Duration
 }
 
 type Server struct {
@@ -41,19 +195,59 @@ type Server struct {
 
 // Config contains application configuration.
 type Config struct {
+	// Store is the storage backend of the deduplication index: "badger" or
+	// "memory".
+	Store string
+
 	// DBPath is the path to the database directory.
 	DBPath string
 
+	// Profiles are named filter profiles, which are served in addition to the
+	// default filter.
+	Profiles []Profile
+
 	Server
 	Tolerance
+	TTL
+	Accuracy
+	Lateness
+	Skew
+	Speed
+	Dwell
+	Sweep
+	Retry
 }
 
 // newConfig returns Config instance with default settings. The Config may not
 // be usable yet.
 func newConfig() *Config {
 	return &Config{
+		Store: defaultStore,
 		Server: Server{
 			Addr: defaultAddr,
 		},
+		Tolerance: Tolerance{
+			Window:   defaultWindowMode,
+			TierMode: defaultTierMode,
+		},
+		Accuracy: Accuracy{
+			Mode: defaultAccuracyMode,
+		},
+		Lateness: Lateness{
+			Policy: defaultLatenessPolicy,
+		},
+		Skew: Skew{
+			Policy: defaultSkewPolicy,
+		},
+		Speed: Speed{
+			Policy: defaultSpeedPolicy,
+		},
+		Sweep: Sweep{
+			Interval: defaultSweepInterval,
+		},
+		Retry: Retry{
+			Attempts: defaultRetryAttempts,
+			Backoff:  defaultRetryBackoff,
+		},
 	}
 }
@@ -4,13 +4,38 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
 const (
+	envStore                   = "STORE"
 	envDBPath                  = "DB_PATH"
+	envProfiles                = "PROFILES"
 	envDistanceTolerance       = "DISTANCE_TOLERANCE"
 	envIntervalTolerance       = "INTERVAL_TOLERANCE"
+	envWindowMode              = "WINDOW_MODE"
+	envTimeBucket              = "TIME_BUCKET"
+	envTiers                   = "TIERS"
+	envTierMode                = "TIER_MODE"
+	envZonesFile               = "ZONES_FILE"
+	envExclusionsFile          = "EXCLUSIONS_FILE"
+	envKeyTTL                  = "KEY_TTL"
+	envTTLGrace                = "TTL_GRACE"
+	envEntityTTL               = "ENTITY_TTL"
+	envAccuracyMode            = "ACCURACY_MODE"
+	envAccuracyMinDistance     = "ACCURACY_MIN_DISTANCE"
+	envAccuracyMaxDistance     = "ACCURACY_MAX_DISTANCE"
+	envAllowedLateness         = "ALLOWED_LATENESS"
+	envLatenessPolicy          = "LATENESS_POLICY"
+	envMaxFutureSkew           = "MAX_FUTURE_SKEW"
+	envSkewPolicy              = "FUTURE_SKEW_POLICY"
+	envMaxSpeed                = "MAX_SPEED"
+	envSpeedPolicy             = "SPEED_POLICY"
+	envDwellMinDuration        = "DWELL_MIN_DURATION"
+	envSweepInterval           = "SWEEP_INTERVAL"
+	envRetryAttempts           = "CONFLICT_RETRY_ATTEMPTS"
+	envRetryBackoff            = "CONFLICT_RETRY_BACKOFF"
 	envServerAddr              = "SERVER_ADDR"
 	envServerReadTimeout       = "SERVER_READ_TIMEOUT"
 	envServerReadHeaderTimeout = "SERVER_READ_HEADER_TIMEOUT"
@@ -20,9 +45,33 @@ const (
 )
 
 var envVars = []string{
+	envStore,
 	envDBPath,
+	envProfiles,
 	envDistanceTolerance,
 	envIntervalTolerance,
+	envWindowMode,
+	envTimeBucket,
+	envTiers,
+	envTierMode,
+	envZonesFile,
+	envExclusionsFile,
+	envKeyTTL,
+	envTTLGrace,
+	envEntityTTL,
+	envAccuracyMode,
+	envAccuracyMinDistance,
+	envAccuracyMaxDistance,
+	envAllowedLateness,
+	envLatenessPolicy,
+	envMaxFutureSkew,
+	envSkewPolicy,
+	envMaxSpeed,
+	envSpeedPolicy,
+	envDwellMinDuration,
+	envSweepInterval,
+	envRetryAttempts,
+	envRetryBackoff,
 	envServerAddr,
 	envServerReadTimeout,
 	envServerReadHeaderTimeout,
@@ -44,12 +93,60 @@ func NewFromEnv() (*Config, error) {
 
 		var err error
 		switch v {
+		case envStore:
+			cfg.Store = val
 		case envDBPath:
 			cfg.DBPath = val
+		case envProfiles:
+			cfg.Profiles, err = parseProfiles(val)
 		case envDistanceTolerance:
 			cfg.Tolerance.Distance, err = strconv.ParseFloat(val, 64)
 		case envIntervalTolerance:
 			cfg.Tolerance.Interval, err = time.ParseDuration(val)
+		case envWindowMode:
+			cfg.Tolerance.Window = val
+		case envTimeBucket:
+			cfg.Tolerance.Bucket, err = time.ParseDuration(val)
+		case envTiers:
+			cfg.Tolerance.Tiers, err = parseTiers(val)
+		case envTierMode:
+			cfg.Tolerance.TierMode = val
+		case envZonesFile:
+			cfg.Tolerance.ZonesFile = val
+		case envExclusionsFile:
+			cfg.Tolerance.ExclusionsFile = val
+		case envKeyTTL:
+			cfg.TTL.Key, err = time.ParseDuration(val)
+		case envTTLGrace:
+			cfg.TTL.Grace, err = time.ParseDuration(val)
+		case envEntityTTL:
+			cfg.TTL.Entities, err = parseDurations(val)
+		case envAccuracyMode:
+			cfg.Accuracy.Mode = val
+		case envAccuracyMinDistance:
+			cfg.Accuracy.MinDistance, err = strconv.ParseFloat(val, 64)
+		case envAccuracyMaxDistance:
+			cfg.Accuracy.MaxDistance, err = strconv.ParseFloat(val, 64)
+		case envAllowedLateness:
+			cfg.Lateness.Allowed, err = time.ParseDuration(val)
+		case envLatenessPolicy:
+			cfg.Lateness.Policy = val
+		case envMaxFutureSkew:
+			cfg.Skew.MaxFuture, err = time.ParseDuration(val)
+		case envSkewPolicy:
+			cfg.Skew.Policy = val
+		case envMaxSpeed:
+			cfg.Speed.Max, err = strconv.ParseFloat(val, 64)
+		case envSpeedPolicy:
+			cfg.Speed.Policy = val
+		case envDwellMinDuration:
+			cfg.Dwell.MinDuration, err = time.ParseDuration(val)
+		case envSweepInterval:
+			cfg.Sweep.Interval, err = time.ParseDuration(val)
+		case envRetryAttempts:
+			cfg.Retry.Attempts, err = strconv.Atoi(val)
+		case envRetryBackoff:
+			cfg.Retry.Backoff, err = time.ParseDuration(val)
 		case envServerAddr:
 			cfg.Server.Addr = val
 		case envServerReadTimeout:
@@ -67,5 +164,83 @@ func NewFromEnv() (*Config, error) {
 			return nil, fmt.Errorf("config: %w", err)
 		}
 	}
+	if cfg.Accuracy.MinDistance == 0 {
+		cfg.Accuracy.MinDistance = cfg.Tolerance.Distance
+	}
+	if cfg.Accuracy.MaxDistance == 0 {
+		cfg.Accuracy.MaxDistance = cfg.Tolerance.Distance
+	}
+	if cfg.Lateness.Allowed == 0 {
+		cfg.Lateness.Allowed = cfg.Tolerance.Interval
+	}
 	return cfg, nil
 }
+
+// parseDurations parses comma separated list of key=duration pairs.
+func parseDurations(s string) (map[string]time.Duration, error) {
+	m := make(map[string]time.Duration)
+	for _, pair := range strings.Split(s, ",") {
+		i := strings.LastIndex(pair, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid key=duration pair %q", pair)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(pair[i+1:]))
+		if err != nil {
+			return nil, err
+		}
+		m[strings.TrimSpace(pair[:i])] = d
+	}
+	return m, nil
+}
+
+// parseTiers parses comma separated list of distance:interval[:level] tiers.
+func parseTiers(s string) ([]Tier, error) {
+	var tiers []Tier
+	for _, spec := range strings.Split(s, ",") {
+		parts := strings.Split(strings.TrimSpace(spec), ":")
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, fmt.Errorf("invalid distance:interval[:level] tier %q", spec)
+		}
+		var (
+			t   Tier
+			err error
+		)
+		if t.Distance, err = strconv.ParseFloat(parts[0], 64); err != nil {
+			return nil, err
+		}
+		if t.Interval, err = time.ParseDuration(parts[1]); err != nil {
+			return nil, err
+		}
+		if len(parts) == 3 {
+			if t.Level, err = strconv.Atoi(parts[2]); err != nil {
+				return nil, err
+			}
+		}
+		tiers = append(tiers, t)
+	}
+	return tiers, nil
+}
+
+// parseProfiles parses comma separated list of name=distance:interval profiles.
+func parseProfiles(s string) ([]Profile, error) {
+	var profiles []Profile
+	for _, spec := range strings.Split(s, ",") {
+		i := strings.Index(spec, "=")
+		parts := strings.Split(strings.TrimSpace(spec[i+1:]), ":")
+		if i < 0 || len(parts) != 2 {
+			return nil, fmt.Errorf("invalid name=distance:interval profile %q", spec)
+		}
+		var (
+			p   = Profile{Name: strings.TrimSpace(spec[:i])}
+			err error
+		)
+		if p.Distance, err = strconv.ParseFloat(parts[0], 64); err != nil {
+			return nil, err
+		}
+		if p.Interval, err = time.ParseDuration(parts[1]); err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles, nil
+}
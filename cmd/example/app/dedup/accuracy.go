@@ -0,0 +1,61 @@
+package dedup
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	// AccuracyIgnore ignores accuracy of events and uses the distance tolerance.
+	AccuracyIgnore AccuracyMode = iota
+
+	// AccuracyMax uses the larger accuracy of the two events as the distance
+	// tolerance between them.
+	AccuracyMax
+
+	// AccuracySum uses the sum of accuracies of the two events as the distance
+	// tolerance between them.
+	AccuracySum
+)
+
+// AccuracyMode defines how horizontal accuracy of events is combined into the
+// distance tolerance between them.
+type AccuracyMode int
+
+// ParseAccuracyMode returns AccuracyMode from its string representation.
+func ParseAccuracyMode(s string) (AccuracyMode, error) {
+	switch s {
+	case "ignore":
+		return AccuracyIgnore, nil
+	case "max":
+		return AccuracyMax, nil
+	case "sum":
+		return AccuracySum, nil
+	}
+	return 0, fmt.Errorf("filter: unknown accuracy mode %q", s)
+}
+
+func (m AccuracyMode) String() string {
+	switch m {
+	case AccuracyIgnore:
+		return "ignore"
+	case AccuracyMax:
+		return "max"
+	case AccuracySum:
+		return "sum"
+	}
+	return fmt.Sprintf("AccuracyMode(%d)", int(m))
+}
+
+// combine returns distance tolerance in meters between two events with given
+// accuracies, bounded by min and max.
+func (m AccuracyMode) combine(a, b, min, max float64) float64 {
+	var d float64
+	switch m {
+	case AccuracyMax:
+		d = math.Max(a, b)
+	case AccuracySum:
+		d = a + b
+	}
+	return math.Min(math.Max(d, min), max)
+}
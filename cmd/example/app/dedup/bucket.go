@@ -0,0 +1,148 @@
+package dedup
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/golang/geo/s2"
+)
+
+// bucketPrefixLen is the length of the time bucket key prefix.
+const bucketPrefixLen = keyLen + timestampLen
+
+// TimeBucket returns the size of time buckets, which location keys are
+// partitioned by. Zero means that keys are not partitioned.
+func (f *SpatioTemporalFilter) TimeBucket() time.Duration {
+	return f.bucket
+}
+
+// locationKey encodes the key of the location in the index. If the keyspace
+// is partitioned, key is prefixed with the time bucket of the location.
+func (f *SpatioTemporalFilter) locationKey(entity string, id s2.CellID, t time.Time, seq uint32) []byte {
+	key := encodeKey(entity, id, t, seq)
+	if f.bucket == 0 {
+		return key
+	}
+	return append(encodeBucketPrefix(f.bucketEnd(t)), key...)
+}
+
+// locationTTL returns TTL of the location keys of the entity. Keys in time
+// buckets never expire, because the whole buckets are dropped.
+func (f *SpatioTemporalFilter) locationTTL(entity string) time.Duration {
+	if f.bucket > 0 {
+		return 0
+	}
+	return f.ttlOf(entity)
+}
+
+// locationRanges returns key ranges of the locations of the entity within the
+// cell, which may be within time tolerance of time t in any zone. If the
+// keyspace is partitioned, there is a range in every time bucket overlapping
+// time tolerance on either side of t, which is one or two buckets, because
+// buckets are at least twice as large as the time tolerance.
+func (f *SpatioTemporalFilter) locationRanges(entity string, id s2.CellID, t time.Time) [][2][]byte {
+	start := encodePrefix(entity, id.RangeMin())
+	end := prefixEnd(encodePrefix(entity, id.RangeMax()))
+	if f.bucket == 0 {
+		return [][2][]byte{{start, end}}
+	}
+
+	var ranges [][2][]byte
+	last := f.bucketEnd(t.Add(f.maxInterval))
+	for b := f.bucketEnd(t.Add(-f.maxInterval)); !b.After(last); b = b.Add(f.bucket) {
+		prefix := encodeBucketPrefix(b)
+		ranges = append(ranges, [2][]byte{
+			append(append([]byte(nil), prefix...), start...),
+			append(append([]byte(nil), prefix...), end...),
+		})
+	}
+	return ranges
+}
+
+// bucketEnd returns the end of the time bucket, which contains time t.
+func (f *SpatioTemporalFilter) bucketEnd(t time.Time) time.Time {
+	return t.Truncate(f.bucket).Add(f.bucket)
+}
+
+// dropBuckets drops time buckets, which end no later than the cutoff, and
+// returns their number. Buckets are dropped by prefix in a single call, if the
+// store supports it, so that writers wait for the drop once per sweep, and key
+// by key otherwise.
+func (f *SpatioTemporalFilter) dropBuckets(cutoff time.Time) (int, error) {
+	prefixes, err := f.expiredBuckets(cutoff)
+	if err != nil || len(prefixes) == 0 {
+		return 0, err
+	}
+	if dropper, ok := f.store.(PrefixDropper); ok {
+		if err := dropper.DropPrefix(prefixes...); err != nil {
+			return 0, err
+		}
+		return len(prefixes), nil
+	}
+	for i, prefix := range prefixes {
+		_, err = f.forget(func(record) bool {
+			return true
+		}, func(Txn) ([][2][]byte, error) {
+			return prefixRanges(prefix), nil
+		})
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(prefixes), nil
+}
+
+// expiredBuckets returns key prefixes of the time buckets, which end no later
+// than the cutoff. Buckets are sorted by their end, so the scan stops at the
+// first bucket, which has not expired.
+func (f *SpatioTemporalFilter) expiredBuckets(cutoff time.Time) ([][]byte, error) {
+	var prefixes [][]byte
+	err := f.store.View(func(txn Txn) error {
+		return scanBuckets(txn, func(prefix []byte) bool {
+			if decodeBucketPrefix(prefix).After(cutoff) {
+				return false
+			}
+			prefixes = append(prefixes, prefix)
+			return true
+		})
+	})
+	return prefixes, err
+}
+
+// scanBuckets calls fn with the key prefix of each time bucket in the order of
+// their end, until fn returns false. Scan seeks from one bucket to the next one.
+func scanBuckets(txn Txn, fn func(prefix []byte) bool) error {
+	start := []byte{BucketKey}
+	return scanPrefixes(txn, start, prefixEnd(start), func(key []byte) int {
+		if len(key) < bucketPrefixLen {
+			return 0
+		}
+		return bucketPrefixLen
+	}, fn)
+}
+
+// encodeBucketPrefix encodes the end of the time bucket into a key prefix of
+// the locations in that bucket. Bucket end rather than its start is encoded,
+// so that buckets expire regardless of their size.
+// Key format is:
+// - 1 byte, key type;
+// - 8 bytes, UNIX timestamp in nanoseconds.
+func encodeBucketPrefix(end time.Time) []byte {
+	buf := make([]byte, bucketPrefixLen)
+	buf[0] = BucketKey
+	binary.BigEndian.PutUint64(buf[keyLen:], uint64(end.UnixNano())^signBit)
+	return buf
+}
+
+// decodeBucketPrefix decodes the end of the time bucket from the key prefix.
+func decodeBucketPrefix(p []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(p[keyLen:])^signBit))
+}
+
+// decodeLocationKey decodes the key of the location in either layout.
+func decodeLocationKey(p []byte) (string, s2.CellID, time.Time, error) {
+	if len(p) > bucketPrefixLen && p[0] == BucketKey {
+		p = p[bucketPrefixLen:]
+	}
+	return decodeKey(p)
+}
@@ -0,0 +1,99 @@
+package dedup
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/golang/geo/s2"
+)
+
+// dropStore is a Store, which records calls of DropPrefix.
+type dropStore struct {
+	*MemoryStore
+	drops [][][]byte
+}
+
+func (s *dropStore) DropPrefix(prefixes ...[]byte) error {
+	s.drops = append(s.drops, prefixes)
+	return s.MemoryStore.DropPrefix(prefixes...)
+}
+
+func TestDropBuckets(t *testing.T) {
+	store := &dropStore{MemoryStore: NewMemoryStore()}
+	filter, err := NewSpatioTemporalFilter(store, 100, time.Minute, WithTimeBuckets(10*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := filter.(*SpatioTemporalFilter)
+
+	start := time.Now().Truncate(time.Hour)
+	for i := 0; i < 6; i++ {
+		_, err := f.Filter(Event{Entity: "entity", Time: start.Add(time.Duration(i) * 10 * time.Minute), Lat: 10, Lng: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// buckets, which end no later than the cutoff, are dropped together.
+	n, err := f.dropBuckets(start.Add(30 * time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 || len(store.drops) != 1 || len(store.drops[0]) != 3 {
+		t.Fatalf("got %d buckets dropped by %d calls, want 3 by 1 call", n, len(store.drops))
+	}
+	prefixes, err := f.expiredBuckets(start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prefixes) != 3 {
+		t.Fatalf("got %d buckets left, want 3", len(prefixes))
+	}
+}
+
+func TestLocationRanges(t *testing.T) {
+	filter, err := NewSpatioTemporalFilter(NewMemoryStore(), 100, time.Minute, WithTimeBuckets(10*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := filter.(*SpatioTemporalFilter)
+
+	start := time.Now().Truncate(time.Hour)
+	id := s2.CellIDFromLatLng(s2.LatLngFromDegrees(10, 10))
+	cell := id.Parent(f.level)
+	tests := []struct {
+		name string
+		t    time.Duration
+		want int
+	}{
+		{name: "within bucket", t: 5 * time.Minute, want: 1},
+		{name: "after bucket start", t: 30 * time.Second, want: 2},
+		{name: "at bucket start", t: 0, want: 2},
+		{name: "tolerance before bucket end", t: 9 * time.Minute, want: 2},
+		{name: "just before tolerance of bucket end", t: 9*time.Minute - time.Nanosecond, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			at := start.Add(tt.t)
+			ranges := f.locationRanges("entity", cell, at)
+			if len(ranges) != tt.want {
+				t.Fatalf("got %d ranges, want %d", len(ranges), tt.want)
+			}
+
+			// locations within time tolerance are within one of the ranges.
+			for d := -f.interval; d <= f.interval; d += time.Second {
+				key := f.locationKey("entity", id, at.Add(d), 0)
+				var found bool
+				for _, r := range ranges {
+					if bytes.Compare(key, r[0]) >= 0 && bytes.Compare(key, r[1]) < 0 {
+						found = true
+					}
+				}
+				if !found {
+					t.Fatalf("location at %v is not within the ranges", d)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package dedup
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/golang/geo/s2"
+)
+
+const (
+	lockStripes = 256
+
+	defaultRetryAttempts = 5
+	defaultRetryBackoff  = time.Millisecond
+)
+
+// ErrConflict is returned when transaction keeps conflicting with concurrent
+// transactions after all retry attempts.
+var ErrConflict = errors.New("filter: transaction conflict, retry attempts exhausted")
+
+// update runs fn in a read-write transaction and retries it with exponential
+// backoff and jitter, if the transaction conflicts with a concurrent one.
+func (f *SpatioTemporalFilter) update(fn func(txn Txn) error) error {
+	backoff := f.retryBackoff
+	for attempt := 1; ; attempt++ {
+		err := f.store.Update(fn)
+		if !errors.Is(err, ErrTxnConflict) {
+			return err
+		}
+		if attempt >= f.retryAttempts {
+			return ErrConflict
+		}
+		time.Sleep(backoff/2 + time.Duration(rand.Int63n(int64(backoff)+1)))
+		backoff *= 2
+	}
+}
+
+// lock locks the stripes of the cells of every tier, which are searched for the
+// earlier events of the given events, and returns a function to unlock them.
+// Events, which search overlapping cells of the same entity, are serialised,
+// while events in unrelated areas run in parallel. Stripes are always locked
+// in the same order to avoid deadlocks.
+func (f *SpatioTemporalFilter) lock(evs ...Event) (unlock func()) {
+	seen := make(map[int]bool)
+	stripes := make([]int, 0, 9*len(evs))
+	for _, ev := range evs {
+		for _, id := range f.tierCells(s2.LatLngFromDegrees(ev.Lat, ev.Lng)) {
+			if i := stripe(ev.Entity, id); !seen[i] {
+				seen[i] = true
+				stripes = append(stripes, i)
+			}
+		}
+	}
+	sort.Ints(stripes)
+	for _, i := range stripes {
+		f.stripes[i].Lock()
+	}
+	return func() {
+		for _, i := range stripes {
+			f.stripes[i].Unlock()
+		}
+	}
+}
+
+// stripe returns the lock stripe of the entity cell.
+func stripe(entity string, id s2.CellID) int {
+	var buf [s2CellIDLen]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(id))
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(entity))
+	_, _ = h.Write(buf[:])
+	return int(h.Sum32() % lockStripes)
+}
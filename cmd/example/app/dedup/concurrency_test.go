@@ -0,0 +1,187 @@
+package dedup
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v2"
+)
+
+// TestFilterParallelDisjoint checks that events of different entities in
+// unrelated areas run in parallel without conflicting transactions.
+func TestFilterParallelDisjoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dedup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := badger.Open(badger.DefaultOptions(dir).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	filter, err := NewSpatioTemporalFilter(NewBadgerStore(db), 100, time.Minute, WithConflictRetry(1, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const (
+		writers = 16
+		events  = 200
+	)
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	errs := make(chan error, writers*events)
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < events; i++ {
+				_, err := filter.Filter(Event{
+					Entity: fmt.Sprintf("entity-%d", w),
+					Time:   start.Add(time.Duration(i) * time.Second),
+					Lat:    float64(w*5 - 40),
+					Lng:    float64(i%50) * 0.01,
+				})
+				if err != nil {
+					errs <- err
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+
+	var conflicts int
+	for err := range errs {
+		if !errors.Is(err, ErrConflict) {
+			t.Fatal(err)
+		}
+		conflicts++
+	}
+	if conflicts > 0 {
+		t.Fatalf("%d of %d events conflicted", conflicts, writers*events)
+	}
+}
+
+// TestFilterParallelSameCell checks that events of the same entity in the same
+// cell are serialised, so that exactly one of them is unique.
+func TestFilterParallelSameCell(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dedup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := badger.Open(badger.DefaultOptions(dir).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	filter, err := NewSpatioTemporalFilter(NewBadgerStore(db), 100, time.Minute, WithConflictRetry(1, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const writers = 16
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	results := make(chan Result, writers)
+	errs := make(chan error, writers)
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			res, err := filter.Filter(Event{
+				Entity: "entity",
+				Time:   start,
+				Lat:    10,
+				Lng:    10 + float64(w)*0.00001,
+			})
+			if err != nil {
+				errs <- err
+				return
+			}
+			results <- res
+		}(w)
+	}
+	wg.Wait()
+	close(results)
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err)
+	}
+	var unique int
+	for res := range results {
+		if res.Unique {
+			unique++
+		}
+	}
+	if unique != 1 {
+		t.Fatalf("got %d unique events, want 1", unique)
+	}
+}
+
+// conflictStore is a Store, which fails the given number of read-write
+// transactions with ErrTxnConflict.
+type conflictStore struct {
+	Store
+	conflicts int
+	attempts  int
+}
+
+func (s *conflictStore) Update(fn func(Txn) error) error {
+	s.attempts++
+	if s.attempts <= s.conflicts {
+		return ErrTxnConflict
+	}
+	return s.Store.Update(fn)
+}
+
+func TestUpdateRetry(t *testing.T) {
+	tests := []struct {
+		name      string
+		conflicts int
+		wantErr   error
+	}{
+		{name: "no conflicts", conflicts: 0},
+		{name: "retried", conflicts: 2},
+		{name: "attempts exhausted", conflicts: 3, wantErr: ErrConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &conflictStore{Store: NewMemoryStore()}
+			filter, err := NewSpatioTemporalFilter(store, 100, time.Minute, WithConflictRetry(3, time.Microsecond))
+			if err != nil {
+				t.Fatal(err)
+			}
+			f := filter.(*SpatioTemporalFilter)
+
+			store.attempts, store.conflicts = 0, tt.conflicts
+			var calls int
+			err = f.update(func(Txn) error {
+				calls++
+				return nil
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			wantCalls := 1
+			if tt.wantErr != nil {
+				wantCalls = 0
+			}
+			if calls != wantCalls {
+				t.Fatalf("got %d calls, want %d", calls, wantCalls)
+			}
+		})
+	}
+}
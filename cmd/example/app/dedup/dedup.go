@@ -1,22 +1,162 @@
 package dedup
 
-import "time"
+import (
+	"time"
+
+	"github.com/golang/geo/s2"
+)
 
 const (
 	SpatioTemporalKey byte = 0x01
+	WatermarkKey      byte = 0x02
+	VersionKey        byte = 0x03
+	DwellKey          byte = 0x04
+	TrackKey          byte = 0x05
+	BucketKey         byte = 0x06
+	TierKey           byte = 0x07
+	ProfileKey        byte = 0x08
+	NamespaceKey      byte = 0x09
+	ExclusionKey      byte = 0x0a
 
 	keyLen = 1
 )
 
 // Filter interface is implemented by event deduplication filters.
 type Filter interface {
-	// Filter processes event and returns true, if it is unique.
-	Filter(Event) (isUnique bool, err error)
+	// Filter processes event and returns the result.
+	Filter(Event) (Result, error)
 }
 
 // Event is a demo event type.
 type Event struct {
-	Time time.Time `json:"time"`
-	Lat  float64   `json:"lat"`
-	Lng  float64   `json:"lng"`
+	// ID is an optional identifier of the event.
+	ID string `json:"id,omitempty"`
+
+	// Entity is an optional identifier of the entity (device, vehicle, etc.)
+	// which has produced the event. Events are only deduplicated against
+	// earlier events of the same entity.
+	Entity string    `json:"entity,omitempty"`
+	Time   time.Time `json:"time"`
+	Lat    float64   `json:"lat"`
+	Lng    float64   `json:"lng"`
+
+	// Accuracy is an optional horizontal accuracy radius in meters.
+	Accuracy float64 `json:"accuracy,omitempty"`
+
+	// Attributes is an optional opaque event payload, which is stored along
+	// with the indexed location.
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// Result is the outcome of filtering an event.
+type Result struct {
+	// Unique is true, if event is unique.
+	Unique bool `json:"unique"`
+
+	// Late is true, if event is older than the allowed lateness.
+	Late bool `json:"late"`
+
+	// Routed is true, if late event was routed to the side channel instead of
+	// being filtered.
+	Routed bool `json:"routed"`
+
+	// Match is the indexed location, which event is a duplicate of.
+	Match *Location `json:"match,omitempty"`
+
+	// Distance is the distance between event and the matched location in
+	// meters.
+	Distance float64 `json:"distance,omitempty"`
+
+	// TimeDelta is the time of event minus the time of the matched location.
+	TimeDelta time.Duration `json:"timeDelta,omitempty"`
+
+	// Tolerance is the distance tolerance in meters between event and the
+	// matched location.
+	Tolerance float64 `json:"tolerance,omitempty"`
+
+	// Flagged is true, if event implies speed of the entity above the maximum
+	// and was not filtered, Speed is that speed in meters per second.
+	Flagged bool    `json:"flagged,omitempty"`
+	Speed   float64 `json:"speed,omitempty"`
+
+	// Excluded is the name of the exclusion zone, which event is within. Such
+	// event is unique and is not indexed.
+	Excluded string `json:"excluded,omitempty"`
+
+	// Zone is the name of the zone, which tolerance was used.
+	Zone string `json:"zone,omitempty"`
+
+	// Tiers are the results of every tier, if filter has additional tiers.
+	// Unique is the combination of their results, while the rest of the fields
+	// are the result of the first tier.
+	Tiers []Result `json:"tiers,omitempty"`
+
+	// Dwell is the dwell event derived from the event, if any.
+	Dwell *DwellEvent `json:"dwell,omitempty"`
+}
+
+// BatchResult is the outcome of filtering an event in a batch.
+type BatchResult struct {
+	Result
+
+	// Err is the error, which event was rejected with.
+	Err error `json:"-"`
+}
+
+// Location is an indexed location. ID and Attributes are copied from the
+// event, which the location was indexed from.
+type Location struct {
+	ID         string                 `json:"id,omitempty"`
+	Entity     string                 `json:"entity,omitempty"`
+	CellID     s2.CellID              `json:"cellId"`
+	Time       time.Time              `json:"time"`
+	Accuracy   float64                `json:"accuracy,omitempty"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+
+	// Count is the number of events merged into the location, including the
+	// one it was indexed from.
+	Count int `json:"count"`
+
+	// FirstSeen and LastSeen are the times of the earliest and the latest
+	// merged events.
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+
+	// Centroid is the mean position of the merged events.
+	Centroid s2.LatLng `json:"centroid"`
+
+	key       []byte
+	expiresAt time.Time
+}
+
+// LatLng returns coordinates of the location.
+func (l Location) LatLng() s2.LatLng {
+	return l.CellID.LatLng()
+}
+
+// merge aggregates duplicate event into the location.
+func (l *Location) merge(ev Event) {
+	c := s2.PointFromLatLng(l.Centroid).Mul(float64(l.Count)).
+		Add(s2.PointFromLatLng(s2.LatLngFromDegrees(ev.Lat, ev.Lng)).Vector)
+	l.Centroid = s2.LatLngFromPoint(s2.Point{Vector: c.Normalize()})
+	l.Count++
+	if ev.Time.Before(l.FirstSeen) {
+		l.FirstSeen = ev.Time
+	}
+	if ev.Time.After(l.LastSeen) {
+		l.LastSeen = ev.Time
+	}
+}
+
+// value returns the payload of the location index entry.
+func (l Location) value() value {
+	return value{
+		ID:         l.ID,
+		Accuracy:   l.Accuracy,
+		Attributes: l.Attributes,
+		Count:      l.Count,
+		FirstSeen:  l.FirstSeen,
+		LastSeen:   l.LastSeen,
+		Centroid:   [2]float64{l.Centroid.Lat.Degrees(), l.Centroid.Lng.Degrees()},
+	}
 }
@@ -0,0 +1,333 @@
+package dedup
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang/geo/s2"
+)
+
+const (
+	// DwellStarted is emitted, once entity has stayed within the distance
+	// tolerance for the minimum dwell duration.
+	DwellStarted DwellEventType = iota
+
+	// DwellEnded is emitted, once entity which has dwelled leaves the distance
+	// tolerance or is not seen for longer than the time tolerance. Entity,
+	// which is not seen any more, is detected by Sweep.
+	DwellEnded
+)
+
+// DwellEventType is the type of the derived dwell event.
+type DwellEventType int
+
+func (t DwellEventType) String() string {
+	switch t {
+	case DwellStarted:
+		return "started"
+	case DwellEnded:
+		return "ended"
+	}
+	return fmt.Sprintf("DwellEventType(%d)", int(t))
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (t DwellEventType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// Dwell is a stay of the entity within the distance tolerance around the
+// location, where it was first seen.
+type Dwell struct {
+	Entity   string    `json:"entity,omitempty"`
+	Center   s2.LatLng `json:"center"`
+	Accuracy float64   `json:"accuracy,omitempty"`
+	Start    time.Time `json:"start"`
+
+	// End is zero, while dwell is ongoing.
+	End      time.Time `json:"end"`
+	LastSeen time.Time `json:"lastSeen"`
+
+	// Count is the number of events within the dwell.
+	Count int `json:"count"`
+}
+
+// Ongoing returns true, if the dwell has not ended.
+func (d Dwell) Ongoing() bool {
+	return d.End.IsZero()
+}
+
+// Duration returns the time between the first and the last event within the
+// dwell.
+func (d Dwell) Duration() time.Duration {
+	return d.LastSeen.Sub(d.Start)
+}
+
+// DwellEvent is the event derived from the location events of the entity.
+type DwellEvent struct {
+	Type  DwellEventType `json:"type"`
+	Dwell Dwell          `json:"dwell"`
+}
+
+// dwellValue is the payload of the dwell entry.
+type dwellValue struct {
+	Center   [2]float64 `json:"center"`
+	Accuracy float64    `json:"accuracy,omitempty"`
+	Start    time.Time  `json:"start"`
+	End      time.Time  `json:"end"`
+	LastSeen time.Time  `json:"lastSeen"`
+	Count    int        `json:"count"`
+
+	// Started is true, once the minimum dwell duration has been reached.
+	Started bool `json:"started"`
+}
+
+func (v dwellValue) dwell(entity string) Dwell {
+	return Dwell{
+		Entity:   entity,
+		Center:   s2.LatLngFromDegrees(v.Center[0], v.Center[1]),
+		Accuracy: v.Accuracy,
+		Start:    v.Start,
+		End:      v.End,
+		LastSeen: v.LastSeen,
+		Count:    v.Count,
+	}
+}
+
+// MinDwell returns the minimum duration of the dwell. Zero means that dwell
+// detection is disabled.
+func (f *SpatioTemporalFilter) MinDwell() time.Duration {
+	return f.minDwell
+}
+
+// Dwells iterates over ongoing and ended dwells of all entities and calls fn
+// with each dwell. Entity stays, which are shorter than the minimum dwell
+// duration, are skipped.
+func (f *SpatioTemporalFilter) Dwells(fn func(Dwell) error) error {
+	prefix := []byte{DwellKey}
+	return f.store.View(func(txn Txn) error {
+		return txn.Scan(prefix, prefixEnd(prefix), func(item Item) error {
+			entity, err := decodeDwellKey(item.Key())
+			if err != nil {
+				return nil // skip keys in unknown format.
+			}
+			val, err := item.Value()
+			if err != nil {
+				return err
+			}
+			v, err := decodeDwellValue(val)
+			if err != nil {
+				return err
+			}
+			if !v.Started {
+				return nil
+			}
+			return fn(v.dwell(entity))
+		})
+	})
+}
+
+// dwell tracks the stay of the event entity in the transaction and returns
+// the derived dwell event, if any. Ongoing stay of the entity is kept under
+// the entity key and is moved under the key with its start time, once it has
+// ended. Events older than the last event of the stay are ignored.
+func (f *SpatioTemporalFilter) dwell(txn Txn, ev Event) (*DwellEvent, error) {
+	key := encodeDwellKey(ev.Entity, time.Time{})
+	ll := s2.LatLngFromDegrees(ev.Lat, ev.Lng)
+	ttl := f.ttlOf(ev.Entity)
+
+	val, err := txn.Get(key)
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return nil, err
+	}
+	if err == nil {
+		v, err := decodeDwellValue(val)
+		if err != nil {
+			return nil, err
+		}
+		if ev.Time.Before(v.LastSeen) {
+			return nil, nil
+		}
+
+		tol := f.toleranceAt(ll)
+		tolerance := chordAngle(f.combine(tol, ev.Accuracy, v.Accuracy))
+		center := s2.PointFromLatLng(s2.LatLngFromDegrees(v.Center[0], v.Center[1]))
+		if ev.Time.Sub(v.LastSeen) <= tol.interval && s2.CompareDistance(s2.PointFromLatLng(ll), center, tolerance) <= 0 {
+			// entity stays.
+			v.LastSeen = ev.Time
+			v.Count++
+			var de *DwellEvent
+			if !v.Started && v.LastSeen.Sub(v.Start) >= f.minDwell {
+				v.Started = true
+				de = &DwellEvent{Type: DwellStarted, Dwell: v.dwell(ev.Entity)}
+			}
+			return de, f.putDwell(txn, key, v, f.ongoingTTL(v, ev.Entity))
+		}
+
+		// entity has left or was lost, stay is replaced with a new one.
+		var de *DwellEvent
+		if v.Started {
+			v.End = v.LastSeen
+			de = &DwellEvent{Type: DwellEnded, Dwell: v.dwell(ev.Entity)}
+			if err := f.putDwell(txn, encodeDwellKey(ev.Entity, v.Start), v, ttl); err != nil {
+				return nil, err
+			}
+		}
+		return de, f.putDwell(txn, key, newDwellValue(ev), ttl)
+	}
+	return nil, f.putDwell(txn, key, newDwellValue(ev), ttl)
+}
+
+// putDwell encodes and stores the dwell entry in the transaction. Entry is
+// created with TTL, so that stays of entities which are not seen any more
+// expire.
+func (f *SpatioTemporalFilter) putDwell(txn Txn, key []byte, v dwellValue, ttl time.Duration) error {
+	val, err := encodeJSONValue(v)
+	if err != nil {
+		return err
+	}
+	return txn.Put(key, val, ttl)
+}
+
+// ongoingTTL returns TTL of the ongoing stay of the entity. Started dwells
+// never expire, so that they are ended by Sweep rather than lost.
+func (f *SpatioTemporalFilter) ongoingTTL(v dwellValue, entity string) time.Duration {
+	if v.Started {
+		return 0
+	}
+	return f.ttlOf(entity)
+}
+
+// endDwells ends started dwells of entities, which have not been seen for
+// longer than the time tolerance within the allowed lateness behind the
+// watermark, calls the dwell events function with DwellEnded events and
+// returns the number of ended dwells.
+func (f *SpatioTemporalFilter) endDwells() (int, error) {
+	if f.minDwell == 0 {
+		return 0, nil
+	}
+	cutoff := f.Watermark().Add(-f.lateness)
+	var entities []string
+	err := f.store.View(func(txn Txn) error {
+		prefix := []byte{DwellKey}
+		return txn.Scan(prefix, prefixEnd(prefix), func(item Item) error {
+			entity, err := decodeDwellKey(item.Key())
+			if err != nil || len(item.Key()) != keyLen+entityLen+len(entity) {
+				return nil // skip ended dwells and keys in unknown format.
+			}
+			val, err := item.Value()
+			if err != nil {
+				return err
+			}
+			v, err := decodeDwellValue(val)
+			if err != nil {
+				return err
+			}
+			if f.staleDwell(v, cutoff) {
+				entities = append(entities, entity)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var ended int
+	for _, entity := range entities {
+		var de *DwellEvent
+		err := f.update(func(txn Txn) error {
+			de = nil
+
+			// dwell is read again, because the entity may have been seen
+			// since the scan.
+			key := encodeDwellKey(entity, time.Time{})
+			val, err := txn.Get(key)
+			if errors.Is(err, ErrKeyNotFound) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			v, err := decodeDwellValue(val)
+			if err != nil || !f.staleDwell(v, cutoff) {
+				return err
+			}
+			v.End = v.LastSeen
+			if err := f.putDwell(txn, encodeDwellKey(entity, v.Start), v, f.ttlOf(entity)); err != nil {
+				return err
+			}
+			de = &DwellEvent{Type: DwellEnded, Dwell: v.dwell(entity)}
+			return txn.Delete(key)
+		})
+		if err != nil {
+			return ended, err
+		}
+		if de != nil {
+			ended++
+			f.emitDwell(Result{Dwell: de})
+		}
+	}
+	return ended, nil
+}
+
+// staleDwell returns true, if the dwell has started and its entity has not
+// been seen for longer than the time tolerance at its center before the cutoff.
+func (f *SpatioTemporalFilter) staleDwell(v dwellValue, cutoff time.Time) bool {
+	tol := f.toleranceAt(s2.LatLngFromDegrees(v.Center[0], v.Center[1]))
+	return v.Started && v.LastSeen.Add(tol.interval).Before(cutoff)
+}
+
+func newDwellValue(ev Event) dwellValue {
+	return dwellValue{
+		Center:   [2]float64{ev.Lat, ev.Lng},
+		Accuracy: ev.Accuracy,
+		Start:    ev.Time,
+		LastSeen: ev.Time,
+		Count:    1,
+	}
+}
+
+// decodeDwellValue decodes given slice of bytes into the dwell payload.
+func decodeDwellValue(p []byte) (dwellValue, error) {
+	var v dwellValue
+	err := decodeJSONValue(p, &v)
+	return v, err
+}
+
+// encodeDwellKey encodes entity and the start time of the ended dwell into a
+// key. Ongoing dwell is keyed by entity only, zero start time.
+// Key format is:
+// - 1 byte, key type;
+// - 2 bytes, entity length;
+// - N bytes, entity;
+// - 8 bytes, UNIX timestamp in nanoseconds, only if dwell has ended.
+func encodeDwellKey(entity string, start time.Time) []byte {
+	n := keyLen + entityLen + len(entity)
+	size := n
+	if !start.IsZero() {
+		size += timestampLen
+	}
+	buf := make([]byte, size)
+	buf[0] = DwellKey
+	binary.BigEndian.PutUint16(buf[keyLen:], uint16(len(entity)))
+	copy(buf[keyLen+entityLen:], entity)
+	if !start.IsZero() {
+		binary.BigEndian.PutUint64(buf[n:], uint64(start.UnixNano())^signBit)
+	}
+	return buf
+}
+
+// decodeDwellKey decodes given slice of bytes (dwell key) into entity.
+func decodeDwellKey(p []byte) (string, error) {
+	if len(p) < keyLen+entityLen || p[0] != DwellKey {
+		return "", errInvalidKey
+	}
+	m := keyLen + entityLen + int(binary.BigEndian.Uint16(p[keyLen:]))
+	if len(p) != m && len(p) != m+timestampLen {
+		return "", errInvalidKey
+	}
+	return string(p[keyLen+entityLen : m]), nil
+}
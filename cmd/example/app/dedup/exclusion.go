@@ -0,0 +1,269 @@
+package dedup
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/golang/geo/s2"
+)
+
+var (
+	// ErrExclusionNotFound is returned when the exclusion zone does not exist.
+	ErrExclusionNotFound = errors.New("filter: exclusion zone not found")
+
+	// ErrInvalidExclusion is returned when the exclusion zone is invalid.
+	ErrInvalidExclusion = errors.New("filter: invalid exclusion zone")
+)
+
+// Exclusion is an area, where deduplication is bypassed and every event is
+// unique. Area is the polygon, the union of cells or both.
+type Exclusion struct {
+	Name  string
+	Loop  *s2.Loop
+	Cells s2.CellUnion
+}
+
+// exclusionValue is the payload of the exclusion zone entry.
+type exclusionValue struct {
+	Loop  [][2]float64 `json:"loop,omitempty"`
+	Cells []string     `json:"cells,omitempty"`
+}
+
+// exclusions is an immutable set of exclusion zones, which is replaced as a
+// whole on change.
+type exclusions struct {
+	zones []Exclusion
+	index *s2.ShapeIndex
+	loops map[s2.Shape]int
+}
+
+// newExclusions indexes exclusion zones sorted by name.
+func newExclusions(zones []Exclusion) *exclusions {
+	sort.Slice(zones, func(i, j int) bool {
+		return zones[i].Name < zones[j].Name
+	})
+	x := exclusions{
+		zones: zones,
+		index: s2.NewShapeIndex(),
+		loops: make(map[s2.Shape]int),
+	}
+	for i, e := range zones {
+		if e.Loop != nil {
+			x.loops[e.Loop] = i
+			x.index.Add(e.Loop)
+		}
+	}
+
+	// set is shared by concurrent queries once returned, so its index is
+	// built before that rather than lazily by the first query.
+	x.index.Begin()
+	return &x
+}
+
+// at returns the exclusion zone, which contains ll, or nil if there is none.
+func (x *exclusions) at(ll s2.LatLng) *Exclusion {
+	q := s2.NewContainsPointQuery(x.index, s2.VertexModelSemiOpen)
+	if shapes := q.ContainingShapes(s2.PointFromLatLng(ll)); len(shapes) > 0 {
+		return &x.zones[x.loops[shapes[0]]]
+	}
+	id := s2.CellIDFromLatLng(ll)
+	for i := range x.zones {
+		if x.zones[i].Cells.ContainsCellID(id) {
+			return &x.zones[i]
+		}
+	}
+	return nil
+}
+
+// Exclusions returns exclusion zones sorted by name.
+func (f *SpatioTemporalFilter) Exclusions() []Exclusion {
+	return append([]Exclusion(nil), f.exclusionSet().zones...)
+}
+
+// ExclusionAt returns the exclusion zone, which contains ll, or nil if there
+// is none.
+func (f *SpatioTemporalFilter) ExclusionAt(ll s2.LatLng) *Exclusion {
+	return f.exclusionSet().at(ll)
+}
+
+// PutExclusion stores the exclusion zone, which replaces the one with the
+// same name. Locations already indexed within the zone are kept.
+func (f *SpatioTemporalFilter) PutExclusion(e Exclusion) error {
+	if err := validateExclusion(&e); err != nil {
+		return err
+	}
+	val, err := encodeExclusionValue(e)
+	if err != nil {
+		return err
+	}
+
+	f.exWriteMu.Lock()
+	defer f.exWriteMu.Unlock()
+	err = f.update(func(txn Txn) error {
+		return txn.Put(encodeExclusionKey(e.Name), val, 0)
+	})
+	if err != nil {
+		return err
+	}
+	zones := []Exclusion{e}
+	for _, z := range f.exclusionSet().zones {
+		if z.Name != e.Name {
+			zones = append(zones, z)
+		}
+	}
+	f.setExclusions(newExclusions(zones))
+	return nil
+}
+
+// DeleteExclusion deletes the exclusion zone.
+func (f *SpatioTemporalFilter) DeleteExclusion(name string) error {
+	f.exWriteMu.Lock()
+	defer f.exWriteMu.Unlock()
+	x := f.exclusionSet()
+	zones := make([]Exclusion, 0, len(x.zones))
+	for _, z := range x.zones {
+		if z.Name != name {
+			zones = append(zones, z)
+		}
+	}
+	if len(zones) == len(x.zones) {
+		return ErrExclusionNotFound
+	}
+	err := f.update(func(txn Txn) error {
+		return txn.Delete(encodeExclusionKey(name))
+	})
+	if err != nil {
+		return err
+	}
+	f.setExclusions(newExclusions(zones))
+	return nil
+}
+
+// exclude returns the unique result of the event within an exclusion zone.
+// Invalid events are left to be rejected by the filter.
+func (f *SpatioTemporalFilter) exclude(ev Event) (Result, bool) {
+	if validate(ev) != nil {
+		return Result{}, false
+	}
+	e := f.ExclusionAt(s2.LatLngFromDegrees(ev.Lat, ev.Lng))
+	if e == nil {
+		return Result{}, false
+	}
+	return Result{Unique: true, Excluded: e.Name}, true
+}
+
+func (f *SpatioTemporalFilter) exclusionSet() *exclusions {
+	f.exMu.RLock()
+	defer f.exMu.RUnlock()
+	return f.exclusions
+}
+
+// setExclusions replaces the set of exclusion zones. Writes of the zones are
+// done before, so that queries are not blocked by the store.
+func (f *SpatioTemporalFilter) setExclusions(x *exclusions) {
+	f.exMu.Lock()
+	defer f.exMu.Unlock()
+	f.exclusions = x
+}
+
+// loadExclusions loads stored exclusion zones and stores the zones given by
+// the options, which replace the stored ones with the same name.
+func (f *SpatioTemporalFilter) loadExclusions(zones []Exclusion) error {
+	f.exclusions = newExclusions(nil)
+	var stored []Exclusion
+	err := f.store.View(func(txn Txn) error {
+		prefix := []byte{ExclusionKey}
+		return txn.Scan(prefix, prefixEnd(prefix), func(item Item) error {
+			val, err := item.Value()
+			if err != nil {
+				return err
+			}
+			e, err := decodeExclusionValue(string(item.Key()[keyLen:]), val)
+			if err != nil {
+				return err
+			}
+			stored = append(stored, e)
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	f.exclusions = newExclusions(stored)
+	for _, e := range zones {
+		if err := f.PutExclusion(e); err != nil {
+			return fmt.Errorf("exclusion %q: %w", e.Name, err)
+		}
+	}
+	return nil
+}
+
+// validateExclusion checks that exclusion zone has a valid name and area and
+// normalises its cells.
+func validateExclusion(e *Exclusion) error {
+	if !nameFormat.MatchString(e.Name) {
+		return fmt.Errorf("%w: name must match %s", ErrInvalidExclusion, nameFormat)
+	}
+	if e.Loop == nil && len(e.Cells) == 0 {
+		return fmt.Errorf("%w: either polygon or cells are required", ErrInvalidExclusion)
+	}
+	if e.Loop != nil {
+		if err := e.Loop.Validate(); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidExclusion, err)
+		}
+	}
+	cells := make(s2.CellUnion, 0, len(e.Cells))
+	for _, id := range e.Cells {
+		if !id.IsValid() {
+			return fmt.Errorf("%w: invalid cell %v", ErrInvalidExclusion, id)
+		}
+		cells = append(cells, id)
+	}
+	cells.Normalize()
+	e.Cells = cells
+	return nil
+}
+
+// encodeExclusionKey encodes the exclusion zone name into a key.
+// Key format is:
+// - 1 byte, key type;
+// - N bytes, exclusion zone name.
+func encodeExclusionKey(name string) []byte {
+	return append([]byte{ExclusionKey}, name...)
+}
+
+// encodeExclusionValue encodes the exclusion zone area into a payload.
+func encodeExclusionValue(e Exclusion) ([]byte, error) {
+	var v exclusionValue
+	if e.Loop != nil {
+		for _, pt := range e.Loop.Vertices() {
+			ll := s2.LatLngFromPoint(pt)
+			v.Loop = append(v.Loop, [2]float64{ll.Lat.Degrees(), ll.Lng.Degrees()})
+		}
+	}
+	for _, id := range e.Cells {
+		v.Cells = append(v.Cells, id.ToToken())
+	}
+	return encodeJSONValue(v)
+}
+
+// decodeExclusionValue decodes given slice of bytes into the exclusion zone.
+func decodeExclusionValue(name string, p []byte) (Exclusion, error) {
+	e := Exclusion{Name: name}
+	var v exclusionValue
+	if err := decodeJSONValue(p, &v); err != nil {
+		return e, err
+	}
+	if len(v.Loop) > 0 {
+		pts := make([]s2.Point, 0, len(v.Loop))
+		for _, c := range v.Loop {
+			pts = append(pts, s2.PointFromLatLng(s2.LatLngFromDegrees(c[0], c[1])))
+		}
+		e.Loop = s2.LoopFromPoints(pts)
+	}
+	for _, token := range v.Cells {
+		e.Cells = append(e.Cells, s2.CellIDFromToken(token))
+	}
+	return e, nil
+}
@@ -0,0 +1,231 @@
+package dedup
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang/geo/s2"
+)
+
+const (
+	// maxRegionCells is the maximum number of cells to cover the region, which
+	// locations are deleted from.
+	maxRegionCells = 64
+
+	// leafLevel is the level of s2 leaf cells.
+	leafLevel = 30
+)
+
+// record is the entity, position and time span of the stored entry.
+type record struct {
+	entity     string
+	ll         s2.LatLng
+	start, end time.Time
+}
+
+// keyRanges returns key ranges [start, end) of the entries to be deleted. It is
+// called within the read-only transaction, which the ranges are scanned in.
+type keyRanges func(txn Txn) ([][2][]byte, error)
+
+// ForgetEntity deletes indexed locations, dwells and the previous accepted
+// event of the entity and returns the number of deleted keys. Scan seeks to
+// the locations of the entity in every time bucket.
+func (f *SpatioTemporalFilter) ForgetEntity(entity string) (int, error) {
+	if len(entity) > maxEntityLen {
+		return 0, ErrEntityTooLong
+	}
+	return f.forgetTiers(func(r record) bool {
+		return r.entity == entity
+	}, func(txn Txn) ([][2][]byte, error) {
+		prefix := encodeEntityPrefix(entity)
+		prefixes := [][]byte{prefix, encodeDwellKey(entity, time.Time{}), encodeTrackKey(entity)}
+		err := scanBuckets(txn, func(bucket []byte) bool {
+			prefixes = append(prefixes, append(bucket, prefix...))
+			return true
+		})
+		return prefixRanges(prefixes...), err
+	})
+}
+
+// ForgetRegion deletes indexed locations, dwells and previous accepted events
+// of all entities within the region and returns the number of deleted keys.
+// Locations are only scanned within the cells covering the region for each
+// entity in every time bucket. Entries are tested against the region itself,
+// and dwells and previous accepted events are scanned for all entities.
+func (f *SpatioTemporalFilter) ForgetRegion(region s2.Region) (int, error) {
+	rc := s2.RegionCoverer{
+		MaxLevel: leafLevel,
+		MaxCells: maxRegionCells,
+	}
+	cu := rc.Covering(region)
+	return f.forgetTiers(func(r record) bool {
+		return region.ContainsPoint(s2.PointFromLatLng(r.ll))
+	}, func(txn Txn) ([][2][]byte, error) {
+		roots := [][]byte{nil}
+		err := scanBuckets(txn, func(bucket []byte) bool {
+			roots = append(roots, bucket)
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		ranges := prefixRanges([]byte{DwellKey}, []byte{TrackKey})
+		for _, root := range roots {
+			start := append(append([]byte(nil), root...), encodeTypePrefix()...)
+			err = scanPrefixes(txn, start, prefixEnd(start), func(key []byte) int {
+				if n := entityPrefixLen(key[len(root):]); n > 0 {
+					return len(root) + n
+				}
+				return 0
+			}, func(prefix []byte) bool {
+				for _, id := range cu {
+					ranges = append(ranges, [2][]byte{
+						appendCellID(prefix, id.RangeMin()),
+						prefixEnd(appendCellID(prefix, id.RangeMax())),
+					})
+				}
+				return true
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		return ranges, nil
+	})
+}
+
+// ForgetTimeRange deletes indexed locations, dwells and previous accepted
+// events of all entities, which time span overlaps time range [start, end),
+// and returns the number of deleted keys.
+func (f *SpatioTemporalFilter) ForgetTimeRange(start, end time.Time) (int, error) {
+	if !start.Before(end) {
+		return 0, errors.New("filter: time range start must be before its end")
+	}
+	return f.forgetTiers(func(r record) bool {
+		return r.start.Before(end) && !r.end.Before(start)
+	}, func(Txn) ([][2][]byte, error) {
+		return prefixRanges(encodeTypePrefix(), []byte{BucketKey}, []byte{DwellKey}, []byte{TrackKey}), nil
+	})
+}
+
+// forgetTiers runs forget in every tier and returns the total number of
+// deleted keys.
+func (f *SpatioTemporalFilter) forgetTiers(fn func(record) bool, ranges keyRanges) (int, error) {
+	var n int
+	for _, t := range append([]*SpatioTemporalFilter{f}, f.tiers...) {
+		m, err := t.forget(fn, ranges)
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// forget deletes the entries within key ranges, for which fn returns true, and
+// returns the number of deleted keys. Keys are collected in a single read-only
+// transaction and deleted in as many transactions as needed, so entries stored
+// after the scan are kept.
+func (f *SpatioTemporalFilter) forget(fn func(record) bool, ranges keyRanges) (int, error) {
+	var keys [][]byte
+	err := f.store.View(func(txn Txn) error {
+		rs, err := ranges(txn)
+		if err != nil {
+			return err
+		}
+		for _, r := range rs {
+			err := txn.Scan(r[0], r[1], func(item Item) error {
+				r, ok, err := decodeRecord(item)
+				if err != nil {
+					return err
+				}
+				if ok && fn(r) {
+					keys = append(keys, append([]byte(nil), item.Key()...))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var deleted int
+	err = inBatches(f.update, len(keys), func(txn Txn, i int) error {
+		return txn.Delete(keys[i])
+	}, func(start, end int) {
+		deleted += end - start
+	})
+	return deleted, err
+}
+
+// prefixRanges returns key ranges of all the keys with given prefixes.
+func prefixRanges(prefixes ...[]byte) [][2][]byte {
+	ranges := make([][2][]byte, len(prefixes))
+	for i, prefix := range prefixes {
+		ranges[i] = [2][]byte{prefix, prefixEnd(prefix)}
+	}
+	return ranges
+}
+
+// decodeRecord decodes entity, position and time span of the stored entry. It
+// returns false, if key is in unknown format.
+func decodeRecord(item Item) (record, bool, error) {
+	key := item.Key()
+	if len(key) < keyLen {
+		return record{}, false, nil
+	}
+
+	switch key[0] {
+	case SpatioTemporalKey, BucketKey:
+		entity, cellID, t, err := decodeLocationKey(key)
+		if err != nil {
+			return record{}, false, nil
+		}
+		return record{entity: entity, ll: cellID.LatLng(), start: t, end: t}, true, nil
+	case DwellKey:
+		entity, err := decodeDwellKey(key)
+		if err != nil {
+			return record{}, false, nil
+		}
+		val, err := item.Value()
+		if err != nil {
+			return record{}, false, err
+		}
+		v, err := decodeDwellValue(val)
+		if err != nil {
+			return record{}, false, err
+		}
+		return record{
+			entity: entity,
+			ll:     s2.LatLngFromDegrees(v.Center[0], v.Center[1]),
+			start:  v.Start,
+			end:    v.LastSeen,
+		}, true, nil
+	case TrackKey:
+		entity, err := decodeTrackKey(key)
+		if err != nil {
+			return record{}, false, nil
+		}
+		val, err := item.Value()
+		if err != nil {
+			return record{}, false, err
+		}
+		v, err := decodeTrackValue(val)
+		if err != nil {
+			return record{}, false, err
+		}
+		return record{
+			entity: entity,
+			ll:     s2.LatLngFromDegrees(v.Lat, v.Lng),
+			start:  v.Time,
+			end:    v.Time,
+		}, true, nil
+	}
+	return record{}, false, nil
+}
@@ -0,0 +1,106 @@
+package dedup
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/golang/geo/s1"
+	"github.com/golang/geo/s2"
+)
+
+func TestForget(t *testing.T) {
+	a, b := s2.LatLngFromDegrees(10, 10), s2.LatLngFromDegrees(20, 20)
+	region := s2.CapFromCenterAngle(s2.PointFromLatLng(a), s1.Angle(1000/earthRadiusMeters))
+	tests := []struct {
+		name   string
+		forget func(f *SpatioTemporalFilter) (int, error)
+		match  func(r record) bool
+	}{
+		{
+			name: "entity",
+			forget: func(f *SpatioTemporalFilter) (int, error) {
+				return f.ForgetEntity("entity-1")
+			},
+			match: func(r record) bool {
+				return r.entity == "entity-1"
+			},
+		},
+		{
+			name: "region",
+			forget: func(f *SpatioTemporalFilter) (int, error) {
+				return f.ForgetRegion(region)
+			},
+			match: func(r record) bool {
+				return region.ContainsPoint(s2.PointFromLatLng(r.ll))
+			},
+		},
+	}
+	for _, bucket := range []time.Duration{0, 10 * time.Minute} {
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("%s bucket %v", tt.name, bucket), func(t *testing.T) {
+				store := NewMemoryStore()
+				filter, err := NewSpatioTemporalFilter(store, 100, time.Minute, WithTimeBuckets(bucket))
+				if err != nil {
+					t.Fatal(err)
+				}
+				f := filter.(*SpatioTemporalFilter)
+
+				start := time.Now().Truncate(time.Hour)
+				for i := 0; i < 6; i++ {
+					ll := a
+					if i%2 == 1 {
+						ll = b
+					}
+					for e := 0; e < 3; e++ {
+						_, err := f.Filter(Event{
+							Entity: fmt.Sprintf("entity-%d", e),
+							Time:   start.Add(time.Duration(i) * 5 * time.Minute),
+							Lat:    ll.Lat.Degrees(),
+							Lng:    ll.Lng.Degrees(),
+						})
+						if err != nil {
+							t.Fatal(err)
+						}
+					}
+				}
+
+				before, matched := testRecords(t, store, tt.match)
+				if matched == 0 {
+					t.Fatal("no records to forget")
+				}
+				n, err := tt.forget(f)
+				if err != nil {
+					t.Fatal(err)
+				}
+				after, left := testRecords(t, store, tt.match)
+				if left > 0 {
+					t.Fatalf("%d matching records left", left)
+				}
+				if n != matched || before-after != matched {
+					t.Fatalf("got %d deleted of %d keys, want %d", n, before-after, matched)
+				}
+			})
+		}
+	}
+}
+
+// testRecords returns the number of keys in the store and the number of
+// records, for which fn returns true.
+func testRecords(t *testing.T, store Store, fn func(record) bool) (keys, matched int) {
+	t.Helper()
+	err := store.View(func(txn Txn) error {
+		return txn.Scan(nil, nil, func(item Item) error {
+			keys++
+			r, ok, err := decodeRecord(item)
+			if ok && fn(r) {
+				matched++
+			}
+			return err
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys, matched
+}
@@ -0,0 +1,128 @@
+package dedup
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/golang/geo/s2"
+)
+
+const (
+	// keyVersion is the current version of the index key format.
+	keyVersion byte = 0x02
+
+	keyVersionLen = 1
+	entityLen     = 2
+	s2CellIDLen   = 8
+	timestampLen  = 8
+	seqLen        = 4
+
+	maxEntityLen = math.MaxUint16
+
+	// signBit flips the sign of UNIX timestamp in nanoseconds, so that keys
+	// with negative timestamps sort before the positive ones.
+	signBit = 1 << 63
+)
+
+// ErrEntityTooLong is returned when the event entity does not fit the index key.
+var ErrEntityTooLong = fmt.Errorf("filter: entity must not be longer than %d bytes", maxEntityLen)
+
+var errInvalidKey = errors.New("filter: invalid index key")
+
+// encodeKey takes entity, cell, time and sequence number and encodes them into
+// a key, which is used in the database index. Sequence number disambiguates
+// locations of the same entity in the same leaf cell at the same time.
+// Key format is:
+// - 1 byte, key type;
+// - 1 byte, key format version;
+// - 2 bytes, entity length;
+// - N bytes, entity;
+// - 8 bytes, s2.CellID, always indexed at the maximum level;
+// - 8 bytes, UNIX timestamp in nanoseconds;
+// - 4 bytes, sequence number.
+func encodeKey(entity string, id s2.CellID, t time.Time, seq uint32) []byte {
+	buf := make([]byte, keyLen+keyVersionLen+entityLen+len(entity)+s2CellIDLen+timestampLen+seqLen)
+	n := putEntityPrefix(buf, entity)
+	binary.BigEndian.PutUint64(buf[n:], uint64(id))
+	binary.BigEndian.PutUint64(buf[n+s2CellIDLen:], uint64(t.UnixNano())^signBit)
+	binary.BigEndian.PutUint32(buf[n+s2CellIDLen+timestampLen:], seq)
+	return buf
+}
+
+// encodePrefix takes entity and cell and encodes them into a key prefix, which
+// is used to scan the database index.
+// Key format is:
+// - 1 byte, key type;
+// - 1 byte, key format version;
+// - 2 bytes, entity length;
+// - N bytes, entity;
+// - 8 bytes, s2.CellID, always indexed at the maximum level.
+func encodePrefix(entity string, id s2.CellID) []byte {
+	buf := make([]byte, keyLen+keyVersionLen+entityLen+len(entity)+s2CellIDLen)
+	n := putEntityPrefix(buf, entity)
+	binary.BigEndian.PutUint64(buf[n:], uint64(id))
+	return buf
+}
+
+// appendCellID returns a copy of the entity prefix with cell id appended.
+func appendCellID(prefix []byte, id s2.CellID) []byte {
+	buf := make([]byte, len(prefix)+s2CellIDLen)
+	binary.BigEndian.PutUint64(buf[copy(buf, prefix):], uint64(id))
+	return buf
+}
+
+// encodeEntityPrefix returns a key prefix, which is shared by all the
+// locations of the entity.
+func encodeEntityPrefix(entity string) []byte {
+	buf := make([]byte, keyLen+keyVersionLen+entityLen+len(entity))
+	putEntityPrefix(buf, entity)
+	return buf
+}
+
+// encodeTypePrefix returns a key prefix, which is shared by all the locations.
+func encodeTypePrefix() []byte {
+	return []byte{SpatioTemporalKey, keyVersion}
+}
+
+// putEntityPrefix writes key type, version and entity into buf and returns the
+// number of bytes written.
+func putEntityPrefix(buf []byte, entity string) int {
+	buf[0] = SpatioTemporalKey
+	buf[keyLen] = keyVersion
+	n := keyLen + keyVersionLen
+	binary.BigEndian.PutUint16(buf[n:], uint16(len(entity)))
+	return n + entityLen + copy(buf[n+entityLen:], entity)
+}
+
+// entityPrefixLen returns the length of the entity prefix of the location key
+// or zero, if the key is malformed.
+func entityPrefixLen(key []byte) int {
+	n := keyLen + keyVersionLen + entityLen
+	if len(key) < n || key[0] != SpatioTemporalKey {
+		return 0
+	}
+	if n += int(binary.BigEndian.Uint16(key[n-entityLen:])); len(key) < n {
+		return 0
+	}
+	return n
+}
+
+// decodeKey decodes given slice of bytes (database index key) into entity,
+// s2.CellID and time.
+func decodeKey(p []byte) (string, s2.CellID, time.Time, error) {
+	n := keyLen + keyVersionLen
+	if len(p) < n+entityLen || p[0] != SpatioTemporalKey || p[keyLen] != keyVersion {
+		return "", 0, time.Time{}, errInvalidKey
+	}
+	m := n + entityLen + int(binary.BigEndian.Uint16(p[n:]))
+	if len(p) != m+s2CellIDLen+timestampLen+seqLen {
+		return "", 0, time.Time{}, errInvalidKey
+	}
+	entity := string(p[n+entityLen : m])
+	id := binary.BigEndian.Uint64(p[m:])
+	ts := binary.BigEndian.Uint64(p[m+s2CellIDLen:]) ^ signBit
+	return entity, s2.CellID(id), time.Unix(0, int64(ts)), nil
+}
@@ -0,0 +1,60 @@
+package dedup
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// LatenessAccept filters late events as usual.
+	LatenessAccept LatenessPolicy = iota
+
+	// LatenessReject rejects late events with LateEventError.
+	LatenessReject
+
+	// LatenessSideChannel routes late events to the side channel without
+	// filtering them.
+	LatenessSideChannel
+)
+
+// LatenessPolicy defines how filter handles events, which are older than the
+// allowed lateness.
+type LatenessPolicy int
+
+// ParseLatenessPolicy returns LatenessPolicy from its string representation.
+func ParseLatenessPolicy(s string) (LatenessPolicy, error) {
+	switch s {
+	case "accept":
+		return LatenessAccept, nil
+	case "reject":
+		return LatenessReject, nil
+	case "side-channel":
+		return LatenessSideChannel, nil
+	}
+	return 0, fmt.Errorf("filter: unknown lateness policy %q", s)
+}
+
+func (p LatenessPolicy) String() string {
+	switch p {
+	case LatenessAccept:
+		return "accept"
+	case LatenessReject:
+		return "reject"
+	case LatenessSideChannel:
+		return "side-channel"
+	}
+	return fmt.Sprintf("LatenessPolicy(%d)", int(p))
+}
+
+// LateEventError is returned when event is rejected, because it is older than
+// the allowed lateness.
+type LateEventError struct {
+	Time      time.Time
+	Watermark time.Time
+	Lateness  time.Duration
+}
+
+func (e *LateEventError) Error() string {
+	return fmt.Sprintf("filter: event at %s is later than %s behind the watermark %s",
+		e.Time.Format(time.RFC3339), e.Lateness, e.Watermark.Format(time.RFC3339))
+}
@@ -0,0 +1,141 @@
+package dedup
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang/geo/s2"
+)
+
+// keyVersion1 is the index key format before keys were versioned, which stores
+// UNIX timestamp in seconds and has no version byte and sequence number. Keys
+// are either in the original layout without entity or in the layout scoped per
+// entity.
+const keyVersion1 byte = 0x01
+
+// keyV1OriginalLen is the length of the index key in the original layout.
+const keyV1OriginalLen = keyLen + s2CellIDLen + timestampLen
+
+// migrate upgrades database index keys to the current key format version.
+// Version is stored under VersionKey. Index without it is assumed to have keys
+// in the unversioned format, which are rewritten preserving values and expiry.
+func migrate(store Store) error {
+	version, err := loadKeyVersion(store)
+	if err != nil {
+		return err
+	}
+	switch {
+	case version == keyVersion:
+		return nil
+	case version > keyVersion:
+		return fmt.Errorf("filter: unsupported index key version %d", version)
+	}
+
+	type rewrite struct {
+		oldKey    []byte
+		newKey    []byte
+		value     []byte
+		expiresAt time.Time
+	}
+	var rewrites []rewrite
+
+	err = store.View(func(txn Txn) error {
+		prefix := []byte{SpatioTemporalKey}
+		return txn.Scan(prefix, prefixEnd(prefix), func(item Item) error {
+			entity, cellID, t, err := decodeKeyV1(item.Key())
+			if err != nil {
+				return nil // skip keys in unknown format.
+			}
+			val, err := item.Value()
+			if err != nil {
+				return err
+			}
+			rewrites = append(rewrites, rewrite{
+				oldKey:    append([]byte(nil), item.Key()...),
+				newKey:    encodeKey(entity, cellID, t, 0),
+				value:     val,
+				expiresAt: item.ExpiresAt(),
+			})
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	// keys are rewritten in as many transactions as needed.
+	err = inBatches(store.Update, len(rewrites), func(txn Txn, i int) error {
+		r := rewrites[i]
+		var ttl time.Duration
+		if !r.expiresAt.IsZero() {
+			ttl = time.Until(r.expiresAt)
+		}
+		// keys, which have expired since the scan, are only deleted.
+		if err := txn.Delete(r.oldKey); err != nil || (!r.expiresAt.IsZero() && ttl <= 0) {
+			return err
+		}
+		return txn.Put(r.newKey, r.value, ttl)
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	// version is stored after all the keys have been rewritten, so that
+	// interrupted migration is resumed on the next start.
+	return store.Update(func(txn Txn) error {
+		return txn.Put([]byte{VersionKey}, []byte{keyVersion}, 0)
+	})
+}
+
+// loadKeyVersion returns index key format version stored in the database.
+func loadKeyVersion(store Store) (byte, error) {
+	version := keyVersion1
+	err := store.View(func(txn Txn) error {
+		val, err := txn.Get([]byte{VersionKey})
+		if errors.Is(err, ErrKeyNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(val) != 1 {
+			return errors.New("filter: invalid index key version")
+		}
+		version = val[0]
+		return nil
+	})
+	return version, err
+}
+
+// decodeKeyV1 decodes index key in the unversioned format. Keys in the
+// original layout belong to the empty entity.
+// Key format is either:
+// - 1 byte, key type;
+// - 8 bytes, s2.CellID, always indexed at the maximum level;
+// - 8 bytes, UNIX timestamp.
+// or:
+// - 1 byte, key type;
+// - 2 bytes, entity length;
+// - N bytes, entity;
+// - 8 bytes, s2.CellID, always indexed at the maximum level;
+// - 8 bytes, UNIX timestamp.
+func decodeKeyV1(p []byte) (string, s2.CellID, time.Time, error) {
+	if len(p) == keyV1OriginalLen && p[0] == SpatioTemporalKey {
+		id := binary.BigEndian.Uint64(p[keyLen:])
+		ts := binary.BigEndian.Uint64(p[keyLen+s2CellIDLen:])
+		return "", s2.CellID(id), time.Unix(int64(ts), 0), nil
+	}
+	if len(p) < keyLen+entityLen || p[0] != SpatioTemporalKey {
+		return "", 0, time.Time{}, errInvalidKey
+	}
+	n := keyLen + entityLen + int(binary.BigEndian.Uint16(p[keyLen:]))
+	if len(p) != n+s2CellIDLen+timestampLen {
+		return "", 0, time.Time{}, errInvalidKey
+	}
+	entity := string(p[keyLen+entityLen : n])
+	id := binary.BigEndian.Uint64(p[n:])
+	ts := binary.BigEndian.Uint64(p[n+s2CellIDLen:])
+	return entity, s2.CellID(id), time.Unix(int64(ts), 0), nil
+}
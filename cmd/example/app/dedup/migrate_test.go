@@ -0,0 +1,94 @@
+package dedup
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/golang/geo/s2"
+)
+
+func TestMigrate(t *testing.T) {
+	id := s2.CellIDFromLatLng(s2.LatLngFromDegrees(10, 10))
+	ts := time.Unix(1600000000, 0)
+	original := make([]byte, keyV1OriginalLen)
+	original[0] = SpatioTemporalKey
+	binary.BigEndian.PutUint64(original[keyLen:], uint64(id))
+	binary.BigEndian.PutUint64(original[keyLen+s2CellIDLen:], uint64(ts.Unix()))
+
+	// entity of each key, which value is the index of the key.
+	entities := []string{"a", "bb", "ccc", ""}
+	var keys [][]byte
+	for _, entity := range entities[:3] {
+		key := []byte{SpatioTemporalKey, 0, byte(len(entity))}
+		key = append(key, entity...)
+		key = append(key, make([]byte, s2CellIDLen+timestampLen)...)
+		n := keyLen + entityLen + len(entity)
+		binary.BigEndian.PutUint64(key[n:], uint64(id))
+		binary.BigEndian.PutUint64(key[n+s2CellIDLen:], uint64(ts.Unix()))
+		keys = append(keys, key)
+	}
+	keys = append(keys, original)
+
+	// every key is rewritten by two writes, so that keys are rewritten in
+	// several transactions.
+	store := &limitStore{Store: NewMemoryStore(), limit: 3}
+	err := store.Store.Update(func(txn Txn) error {
+		for i, key := range keys {
+			var ttl time.Duration
+			if i%2 == 0 {
+				ttl = time.Hour
+			}
+			if err := txn.Put(key, []byte{byte(i)}, ttl); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrate(store); err != nil {
+		t.Fatal(err)
+	}
+
+	var migrated int
+	err = store.View(func(txn Txn) error {
+		prefix := []byte{SpatioTemporalKey}
+		return txn.Scan(prefix, prefixEnd(prefix), func(item Item) error {
+			entity, cellID, tm, err := decodeKey(item.Key())
+			if err != nil {
+				t.Fatalf("key %x is not migrated: %v", item.Key(), err)
+			}
+			if cellID != id || !tm.Equal(ts) {
+				t.Fatalf("got cell %v at %v, want %v at %v", cellID, tm, id, ts)
+			}
+			val, err := item.Value()
+			if err != nil {
+				return err
+			}
+			i := val[0]
+			if entity != entities[i] {
+				t.Fatalf("got entity %q, want %q", entity, entities[i])
+			}
+			if expires := !item.ExpiresAt().IsZero(); expires != (i%2 == 0) {
+				t.Fatalf("got expiry %v of entity %q", item.ExpiresAt(), entity)
+			}
+			migrated++
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated != len(keys) {
+		t.Fatalf("got %d migrated keys, want %d", migrated, len(keys))
+	}
+	version, err := loadKeyVersion(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != keyVersion {
+		t.Fatalf("got version %d, want %d", version, keyVersion)
+	}
+}
@@ -0,0 +1,146 @@
+package dedup
+
+import "time"
+
+// Option configures SpatioTemporalFilter.
+type Option func(*SpatioTemporalFilter)
+
+// WithLateness sets the allowed lateness of events behind the watermark and
+// the policy for events, which are later than that. Allowed lateness defaults
+// to the time tolerance.
+func WithLateness(allowed time.Duration, policy LatenessPolicy) Option {
+	return func(f *SpatioTemporalFilter) {
+		f.lateness = allowed
+		f.latenessPolicy = policy
+	}
+}
+
+// WithLateEvents sets the side channel for late events. It is required by
+// LatenessSideChannel policy.
+func WithLateEvents(fn func(Event)) Option {
+	return func(f *SpatioTemporalFilter) {
+		f.lateEvents = fn
+	}
+}
+
+// WithMaxFutureSkew sets the maximum allowed skew of event time ahead of the
+// server time and the policy for events, which are dated further in the future
+// than that. Zero skew disables the check.
+func WithMaxFutureSkew(skew time.Duration, policy SkewPolicy) Option {
+	return func(f *SpatioTemporalFilter) {
+		f.maxSkew = skew
+		f.skewPolicy = policy
+	}
+}
+
+// WithConflictRetry sets the maximum number of attempts to run a transaction,
+// which conflicts with concurrent transactions, and the initial backoff between
+// attempts, which doubles after each attempt.
+func WithConflictRetry(attempts int, backoff time.Duration) Option {
+	return func(f *SpatioTemporalFilter) {
+		f.retryAttempts = attempts
+		f.retryBackoff = backoff
+	}
+}
+
+// WithAccuracy sets the mode to combine horizontal accuracy of two events into
+// the distance tolerance between them and the bounds of that tolerance in
+// meters. Bounds default to the distance tolerance, which disables the mode.
+func WithAccuracy(mode AccuracyMode, min, max float64) Option {
+	return func(f *SpatioTemporalFilter) {
+		f.accuracyMode = mode
+		f.minDistance = min
+		f.maxDistance = max
+	}
+}
+
+// WithWindow sets the mode how duplicates affect the time window of the
+// matched location. Defaults to WindowTumbling.
+func WithWindow(mode WindowMode) Option {
+	return func(f *SpatioTemporalFilter) {
+		f.window = mode
+	}
+}
+
+// WithTTL sets time to live of the index entries. Zero TTL defaults to the time
+// tolerance plus the allowed lateness and the grace margin. TTL must not be
+// shorter than the time tolerance.
+func WithTTL(ttl, grace time.Duration) Option {
+	return func(f *SpatioTemporalFilter) {
+		f.ttl = ttl
+		f.grace = grace
+	}
+}
+
+// WithEntityTTL sets time to live of the index entries of the given entities,
+// which overrides the filter TTL. Entity TTL must not be longer than the filter
+// TTL and is not supported with time buckets.
+func WithEntityTTL(ttls map[string]time.Duration) Option {
+	return func(f *SpatioTemporalFilter) {
+		f.entityTTL = make(map[string]time.Duration, len(ttls))
+		for entity, ttl := range ttls {
+			f.entityTTL[entity] = ttl
+		}
+	}
+}
+
+// WithTimeBuckets partitions location keys by time buckets of the given size,
+// so that expired buckets are dropped as a whole by the sweeper instead of
+// keys expiring one by one. Buckets must be at least twice as large as the
+// time tolerance in any zone and tier, so that events are matched against one
+// or two buckets. Expired buckets of each tier are dropped in a single call per
+// sweep, if the store supports dropping by prefix, which stalls writes to the
+// whole store until the drop is done. Zero size keeps keys of each entity
+// together.
+func WithTimeBuckets(size time.Duration) Option {
+	return func(f *SpatioTemporalFilter) {
+		f.bucket = size
+	}
+}
+
+// WithZones sets zones with their own distance and time tolerance, which
+// override the filter tolerance for events within them. Zones listed first
+// take precedence, where zones overlap.
+func WithZones(zones ...Zone) Option {
+	return func(f *SpatioTemporalFilter) {
+		f.zones = append([]Zone(nil), zones...)
+	}
+}
+
+// WithExclusions adds exclusion zones, where deduplication is bypassed. Zones
+// are stored and replace the stored ones with the same name.
+func WithExclusions(zones ...Exclusion) Option {
+	return func(f *SpatioTemporalFilter) {
+		f.exclusionSpecs = append([]Exclusion(nil), zones...)
+	}
+}
+
+// WithTiers adds tiers of distance and time tolerance, which events are
+// deduplicated with in addition to the filter tolerance, and sets how results
+// of the tiers are combined.
+func WithTiers(mode TierMode, tiers ...Tier) Option {
+	return func(f *SpatioTemporalFilter) {
+		f.tierMode = mode
+		f.tierSpecs = append([]Tier(nil), tiers...)
+	}
+}
+
+// WithDwell enables dwell detection with the minimum duration of the dwell.
+// Function fn is called with derived dwell events, once they are committed to
+// the store. It may be nil.
+func WithDwell(min time.Duration, fn func(DwellEvent)) Option {
+	return func(f *SpatioTemporalFilter) {
+		f.minDwell = min
+		f.dwellEvents = fn
+	}
+}
+
+// WithMaxSpeed sets the maximum speed of entities in meters per second and the
+// policy for events, which imply higher speed since the previous accepted
+// event of the entity. Zero speed disables the check.
+func WithMaxSpeed(speed float64, policy SpeedPolicy) Option {
+	return func(f *SpatioTemporalFilter) {
+		f.maxSpeed = speed
+		f.speedPolicy = policy
+	}
+}
@@ -0,0 +1,317 @@
+package dedup
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultProfile is the name of the profile of the default filter, which keeps
+// its index in the root of the store.
+const DefaultProfile = "default"
+
+// profileNameLen is the size of the profile name length in the key prefix.
+const profileNameLen = 2
+
+var (
+	// ErrProfileExists is returned when the profile with the same name exists.
+	ErrProfileExists = errors.New("filter: profile already exists")
+
+	// ErrProfileNotFound is returned when the profile does not exist.
+	ErrProfileNotFound = errors.New("filter: profile not found")
+
+	// ErrInvalidProfile is returned when the profile parameters are invalid.
+	ErrInvalidProfile = errors.New("filter: invalid profile")
+
+	// ErrSweeperRequired is returned when the profile uses time buckets or
+	// dwell detection, and the registry runs no sweeper.
+	ErrSweeperRequired = errors.New("filter: sweeper is required by time buckets and dwell detection")
+
+	// nameFormat is the format of profile and exclusion names, which are safe
+	// to use in URL paths.
+	nameFormat = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+)
+
+// Profile is a named set of filter parameters. Zones, exclusions and tiers are
+// only used by the default filter.
+type Profile struct {
+	Name string `json:"name"`
+
+	// Distance is distance tolerance in meters.
+	Distance float64 `json:"distance"`
+
+	// Interval is time tolerance.
+	Interval time.Duration `json:"interval"`
+
+	Window WindowMode    `json:"window"`
+	Bucket time.Duration `json:"bucket"`
+
+	// TTL is time to live of the index entries. Zero derives it from the time
+	// tolerance, the allowed lateness and the grace margin.
+	TTL   time.Duration `json:"ttl"`
+	Grace time.Duration `json:"grace"`
+
+	// EntityTTL is time to live of the index entries of the given entities,
+	// which overrides TTL.
+	EntityTTL map[string]time.Duration `json:"entityTTL,omitempty"`
+
+	// Accuracy is the mode to combine horizontal accuracy of events, which
+	// distance tolerance is bound by MinDistance and MaxDistance in meters.
+	// Zero bounds default to the distance tolerance.
+	Accuracy    AccuracyMode `json:"accuracy"`
+	MinDistance float64      `json:"minDistance"`
+	MaxDistance float64      `json:"maxDistance"`
+
+	// Lateness is the allowed lateness of events. Zero defaults to the time
+	// tolerance.
+	Lateness       time.Duration  `json:"lateness"`
+	LatenessPolicy LatenessPolicy `json:"latenessPolicy"`
+}
+
+// Registry is a set of named filters, which share the same store. Each filter
+// keeps its index under its own key prefix, except the default one. Profiles
+// created at runtime are stored too, so that they are restored by the next
+// registry over the same store.
+type Registry struct {
+	store Store
+	def   *SpatioTemporalFilter
+	opts  []Option
+
+	mu       sync.RWMutex
+	filters  map[string]*SpatioTemporalFilter
+	sweeping bool
+}
+
+// NewRegistry creates a registry with the default filter and restores the
+// stored profiles. Options are applied to the filters of every profile before
+// the profile parameters, so that the profiles inherit them.
+func NewRegistry(store Store, def *SpatioTemporalFilter, opts ...Option) (*Registry, error) {
+	r := Registry{
+		store:   store,
+		def:     def,
+		opts:    opts,
+		filters: make(map[string]*SpatioTemporalFilter),
+	}
+
+	var profiles []Profile
+	err := store.View(func(txn Txn) error {
+		prefix := []byte{ProfileKey}
+		return txn.Scan(prefix, prefixEnd(prefix), func(item Item) error {
+			val, err := item.Value()
+			if err != nil {
+				return err
+			}
+			p, err := decodeProfileValue(val)
+			if err != nil {
+				return err
+			}
+			profiles = append(profiles, p)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range profiles {
+		f, err := r.newFilter(p)
+		if err != nil {
+			return nil, fmt.Errorf("profile %q: %w", p.Name, err)
+		}
+		r.filters[p.Name] = f
+	}
+	return &r, nil
+}
+
+// Get returns the filter of the profile.
+func (r *Registry) Get(name string) (*SpatioTemporalFilter, error) {
+	if name == DefaultProfile {
+		return r.def, nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	f, ok := r.filters[name]
+	if !ok {
+		return nil, ErrProfileNotFound
+	}
+	return f, nil
+}
+
+// Profiles returns the default profile followed by the other profiles sorted
+// by name. Parameters are reported as the filters use them, with defaults
+// applied.
+func (r *Registry) Profiles() []Profile {
+	profiles := []Profile{makeProfile(DefaultProfile, r.def)}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.filters))
+	for name := range r.filters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		profiles = append(profiles, makeProfile(name, r.filters[name]))
+	}
+	return profiles
+}
+
+// Create creates the filter of the profile, stores the profile and returns it
+// with defaults applied. Profile created with the same name overrides the
+// stored one, if override is true. Profiles, which use time buckets or dwell
+// detection, are only created, once the sweeper runs.
+func (r *Registry) Create(p Profile, override bool) (Profile, error) {
+	if p.Name == DefaultProfile {
+		return p, ErrProfileExists
+	}
+	if !nameFormat.MatchString(p.Name) {
+		return p, fmt.Errorf("%w: name must match %s", ErrInvalidProfile, nameFormat)
+	}
+	if p.Lateness == 0 {
+		p.Lateness = p.Interval
+	}
+	val, err := encodeJSONValue(p)
+	if err != nil {
+		return p, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.filters[p.Name]; ok && !override {
+		return p, ErrProfileExists
+	}
+	f, err := r.newFilter(p)
+	if err != nil {
+		return p, fmt.Errorf("%w: %v", ErrInvalidProfile, err)
+	}
+	if !r.sweeping && (f.TimeBucket() > 0 || f.MinDwell() > 0) {
+		return p, ErrSweeperRequired
+	}
+	err = r.store.Update(func(txn Txn) error {
+		return txn.Put(encodeProfileKey(p.Name), val, 0)
+	})
+	if err != nil {
+		return p, err
+	}
+	r.filters[p.Name] = f
+	return makeProfile(p.Name, f), nil
+}
+
+// Delete deletes the profile and the index of its filter. Requests, which are
+// still running on the filter, may leave some of their keys behind. Keys are
+// deleted one by one rather than dropped by prefix, so that writes of the other
+// filters do not wait for the drop.
+func (r *Registry) Delete(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.filters[name]; !ok {
+		return ErrProfileNotFound
+	}
+	err := r.store.Update(func(txn Txn) error {
+		return txn.Delete(encodeProfileKey(name))
+	})
+	if err != nil {
+		return err
+	}
+	delete(r.filters, name)
+
+	return deletePrefix(r.store, encodeNamespacePrefix(name))
+}
+
+// RunSweeper runs Sweep of every filter in the background every interval and
+// calls fn with the outcome of each sweep. It returns a function, which stops
+// the sweeper and waits for the running sweep to finish.
+func (r *Registry) RunSweeper(interval time.Duration, fn func(string, SweepStats, error)) (stop func()) {
+	r.mu.Lock()
+	r.sweeping = true
+	r.mu.Unlock()
+	return every(interval, func() {
+		for _, p := range r.Profiles() {
+			f, err := r.Get(p.Name)
+			if err != nil {
+				continue // deleted since.
+			}
+			stats, err := f.Sweep()
+			if fn != nil {
+				fn(p.Name, stats, err)
+			}
+		}
+	})
+}
+
+// newFilter creates the filter of the profile, which keeps its index under
+// the key prefix of the profile.
+func (r *Registry) newFilter(p Profile) (*SpatioTemporalFilter, error) {
+	if p.MinDistance == 0 {
+		p.MinDistance = p.Distance
+	}
+	if p.MaxDistance == 0 {
+		p.MaxDistance = p.Distance
+	}
+	opts := append(append([]Option(nil), r.opts...),
+		WithWindow(p.Window),
+		WithTimeBuckets(p.Bucket),
+		WithTTL(p.TTL, p.Grace),
+		WithEntityTTL(p.EntityTTL),
+		WithAccuracy(p.Accuracy, p.MinDistance, p.MaxDistance),
+		WithLateness(p.Lateness, p.LatenessPolicy),
+	)
+	f, err := NewSpatioTemporalFilter(newPrefixedStore(r.store, encodeNamespacePrefix(p.Name)), p.Distance, p.Interval, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return f.(*SpatioTemporalFilter), nil
+}
+
+// makeProfile returns the parameters of the filter as the named profile.
+func makeProfile(name string, f *SpatioTemporalFilter) Profile {
+	lateness, policy := f.Lateness()
+	mode, min, max := f.Accuracy()
+	return Profile{
+		Name:           name,
+		Distance:       f.Distance(),
+		Interval:       f.Interval(),
+		Window:         f.Window(),
+		Bucket:         f.TimeBucket(),
+		TTL:            f.TTL(),
+		Grace:          f.grace,
+		EntityTTL:      f.EntityTTL(),
+		Accuracy:       mode,
+		MinDistance:    min,
+		MaxDistance:    max,
+		Lateness:       lateness,
+		LatenessPolicy: policy,
+	}
+}
+
+// decodeProfileValue decodes given slice of bytes into the profile.
+func decodeProfileValue(p []byte) (Profile, error) {
+	var v Profile
+	err := decodeJSONValue(p, &v)
+	return v, err
+}
+
+// encodeProfileKey encodes the profile name into a key.
+// Key format is:
+// - 1 byte, key type;
+// - N bytes, profile name.
+func encodeProfileKey(name string) []byte {
+	return append([]byte{ProfileKey}, name...)
+}
+
+// encodeNamespacePrefix encodes the profile name into a key prefix of its
+// index. Name length is encoded, so that no prefix is a prefix of another one.
+// Key format is:
+// - 1 byte, key type;
+// - 2 bytes, profile name length;
+// - N bytes, profile name.
+func encodeNamespacePrefix(name string) []byte {
+	buf := make([]byte, keyLen+profileNameLen+len(name))
+	buf[0] = NamespaceKey
+	binary.BigEndian.PutUint16(buf[keyLen:], uint16(len(name)))
+	copy(buf[keyLen+profileNameLen:], name)
+	return buf
+}
@@ -0,0 +1,57 @@
+package dedup
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRegistryCreate(t *testing.T) {
+	store := NewMemoryStore()
+	def, err := NewSpatioTemporalFilter(store, 100, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, err := NewRegistry(store, def.(*SpatioTemporalFilter), WithConflictRetry(5, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bucketed := Profile{Name: "bucketed", Distance: 50, Interval: time.Minute, Bucket: time.Hour}
+	if _, err = reg.Create(bucketed, false); !errors.Is(err, ErrSweeperRequired) {
+		t.Fatalf("got error %v without sweeper, want %v", err, ErrSweeperRequired)
+	}
+	stop := reg.RunSweeper(time.Hour, nil)
+	defer stop()
+	if _, err = reg.Create(bucketed, false); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := reg.Create(Profile{Name: "plain", Distance: 50, Interval: time.Minute, Grace: time.Second}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := reg.Get("plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.TTL == 0 || p.TTL != f.TTL() {
+		t.Fatalf("got TTL %v, want %v", p.TTL, f.TTL())
+	}
+	if attempts := f.retryAttempts; attempts != 5 {
+		t.Fatalf("got %d retry attempts, want inherited 5", attempts)
+	}
+
+	// profiles are restored by the next registry over the same store.
+	reg, err = NewRegistry(store, def.(*SpatioTemporalFilter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	profiles := reg.Profiles()
+	if len(profiles) != 3 || profiles[1].Name != "bucketed" || profiles[2].Name != "plain" {
+		t.Fatalf("got profiles %v", profiles)
+	}
+	if profiles[2].TTL != p.TTL {
+		t.Fatalf("got restored TTL %v, want %v", profiles[2].TTL, p.TTL)
+	}
+}
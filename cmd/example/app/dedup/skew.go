@@ -0,0 +1,52 @@
+package dedup
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// SkewReject rejects future-dated events with FutureEventError.
+	SkewReject SkewPolicy = iota
+
+	// SkewClamp clamps time of future-dated events to the maximum allowed.
+	SkewClamp
+)
+
+// SkewPolicy defines how filter handles events, which are dated further in
+// the future than the maximum clock skew allows.
+type SkewPolicy int
+
+// ParseSkewPolicy returns SkewPolicy from its string representation.
+func ParseSkewPolicy(s string) (SkewPolicy, error) {
+	switch s {
+	case "reject":
+		return SkewReject, nil
+	case "clamp":
+		return SkewClamp, nil
+	}
+	return 0, fmt.Errorf("filter: unknown skew policy %q", s)
+}
+
+func (p SkewPolicy) String() string {
+	switch p {
+	case SkewReject:
+		return "reject"
+	case SkewClamp:
+		return "clamp"
+	}
+	return fmt.Sprintf("SkewPolicy(%d)", int(p))
+}
+
+// FutureEventError is returned when event is rejected, because it is dated
+// further in the future than the maximum clock skew allows.
+type FutureEventError struct {
+	Time    time.Time
+	Now     time.Time
+	MaxSkew time.Duration
+}
+
+func (e *FutureEventError) Error() string {
+	return fmt.Sprintf("filter: event at %s is more than %s ahead of the server time %s",
+		e.Time.Format(time.RFC3339), e.MaxSkew, e.Now.Format(time.RFC3339))
+}
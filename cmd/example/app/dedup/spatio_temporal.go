@@ -1,48 +1,190 @@
 package dedup
 
 import (
-	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"math"
+	"math/rand"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/dgraph-io/badger/v2"
 	"github.com/golang/geo/s1"
 	"github.com/golang/geo/s2"
 )
 
 const (
 	earthRadiusMeters = 6371010.0
-	locationsTTL      = 24 * time.Hour
+	maxCoveringCells  = 16
 )
 
+// errStopScan stops Txn.Scan, once the result is found.
+var errStopScan = errors.New("filter: stop scan")
+
 // SpatioTemporalFilter implements spatio-temporal deduplication filter.
 type SpatioTemporalFilter struct {
-	db       *badger.DB
+	// skew and sweep counters are accessed atomically and must stay 64-bit
+	// aligned.
+	skewClamped  uint64
+	skewRejected uint64
+	sweeps       uint64
+	sweptKeys    uint64
+	dropped      uint64
+	collected    uint64
+
+	// seq is the sequence number of the last indexed location.
+	seq uint32
+
+	store    Store
 	distance s1.ChordAngle
 	interval time.Duration
 	level    int
+	window   WindowMode
+	bucket   time.Duration
+
+	zones       []Zone
+	zoneIndex   *s2.ShapeIndex
+	zoneShapes  map[s2.Shape]int
+	maxInterval time.Duration
+
+	// exWriteMu serialises changes of exclusion zones, so that the set is
+	// replaced in the order the zones are stored, and exMu guards the set.
+	exWriteMu      sync.Mutex
+	exMu           sync.RWMutex
+	exclusions     *exclusions
+	exclusionSpecs []Exclusion
+
+	tierMode  TierMode
+	tierSpecs []Tier
+	tiers     []*SpatioTemporalFilter
+	parent    *SpatioTemporalFilter
+
+	ttl       time.Duration
+	grace     time.Duration
+	entityTTL map[string]time.Duration
+
+	accuracyMode AccuracyMode
+	minDistance  float64
+	maxDistance  float64
+
+	lateness       time.Duration
+	latenessPolicy LatenessPolicy
+	lateEvents     func(Event)
+
+	maxSpeed    float64
+	speedPolicy SpeedPolicy
+
+	minDwell    time.Duration
+	dwellEvents func(DwellEvent)
+
+	maxSkew    time.Duration
+	skewPolicy SkewPolicy
+	now        func() time.Time
+
+	retryAttempts int
+	retryBackoff  time.Duration
+	stripes       [lockStripes]sync.Mutex
 
 	mu        sync.RWMutex
 	watermark time.Time
+
+	// storeMu serialises writes of the watermark, which was last persisted.
+	storeMu sync.Mutex
+	stored  time.Time
 }
 
-// NewSpatioTemporalFilter creates and returns an instance of the deduplication Filter.
-func NewSpatioTemporalFilter(db *badger.DB, distance float64, interval time.Duration) (Filter, error) {
+// NewSpatioTemporalFilter creates and returns an instance of the deduplication
+// Filter, which keeps its index in the store.
+func NewSpatioTemporalFilter(store Store, distance float64, interval time.Duration, opts ...Option) (Filter, error) {
 	switch {
 	case distance <= 0:
 		return nil, errors.New("filter: distance tolerance between events must be greater than zero")
 	case interval <= 0:
 		return nil, errors.New("filter: time tolerance between events must be greater than zero")
 	}
-	rad := distance / earthRadiusMeters
 	f := SpatioTemporalFilter{
-		db:       db,
-		distance: s1.ChordAngleFromAngle(s1.Angle(rad)),
-		interval: interval,
-		level:    s2.MinEdgeMetric.ClosestLevel(rad),
+		store:         store,
+		distance:      chordAngle(distance),
+		interval:      interval,
+		minDistance:   distance,
+		maxDistance:   distance,
+		lateness:      interval,
+		now:           time.Now,
+		seq:           rand.New(rand.NewSource(time.Now().UnixNano())).Uint32(),
+		retryAttempts: defaultRetryAttempts,
+		retryBackoff:  defaultRetryBackoff,
+	}
+	for _, opt := range opts {
+		opt(&f)
+	}
+	switch {
+	case f.lateness < 0:
+		return nil, errors.New("filter: allowed lateness must not be negative")
+	case f.latenessPolicy == LatenessSideChannel && f.lateEvents == nil:
+		return nil, errors.New("filter: side channel is required for late events")
+	case f.maxSkew < 0:
+		return nil, errors.New("filter: maximum future skew must not be negative")
+	case f.grace < 0:
+		return nil, errors.New("filter: TTL grace margin must not be negative")
+	case f.bucket < 0:
+		return nil, errors.New("filter: time bucket size must not be negative")
+	case f.maxSpeed < 0 || math.IsNaN(f.maxSpeed):
+		return nil, errors.New("filter: maximum speed must not be negative")
+	case f.minDwell < 0:
+		return nil, errors.New("filter: minimum dwell duration must not be negative")
+	case f.retryAttempts <= 0:
+		return nil, errors.New("filter: number of transaction attempts must be greater than zero")
+	case f.retryBackoff < 0:
+		return nil, errors.New("filter: transaction retry backoff must not be negative")
+	case f.minDistance <= 0 || f.maxDistance < f.minDistance:
+		return nil, errors.New("filter: accuracy tolerance bounds must be greater than zero and ordered")
+	}
+
+	if err := f.indexZones(); err != nil {
+		return nil, err
+	}
+	if f.bucket > 0 && f.bucket < 2*f.maxInterval {
+		return nil, errors.New("filter: time bucket size must be at least twice the time tolerance in any zone")
+	}
+
+	// index entries live as long as they can match events within the allowed
+	// lateness in any zone, unless TTL is set explicitly.
+	if f.ttl == 0 {
+		f.ttl = f.maxInterval + f.lateness + f.grace
+	}
+	if f.ttl < f.maxInterval {
+		return nil, errors.New("filter: TTL must not be shorter than the time tolerance")
+	}
+	// entries of all entities are matched and swept by the same cutoff, so
+	// that entity TTL may only expire them earlier.
+	if len(f.entityTTL) > 0 && f.bucket > 0 {
+		return nil, errors.New("filter: TTL of entities is not supported with time buckets")
+	}
+	for entity, ttl := range f.entityTTL {
+		switch {
+		case ttl < f.maxInterval:
+			return nil, fmt.Errorf("filter: TTL of entity %q must not be shorter than the time tolerance", entity)
+		case ttl > f.ttl:
+			return nil, fmt.Errorf("filter: TTL of entity %q must not be longer than the filter TTL", entity)
+		}
+	}
+
+	// cells must be large enough to cover the largest tolerance.
+	f.level = s2.MinWidthMetric.MaxLevel(f.searchDistance().Angle().Radians())
+
+	if err := migrate(store); err != nil {
+		return nil, err
+	}
+	if err := f.loadWatermark(); err != nil {
+		return nil, err
+	}
+	if err := f.loadExclusions(f.exclusionSpecs); err != nil {
+		return nil, err
+	}
+	if err := f.newTiers(f.tierSpecs); err != nil {
+		return nil, err
 	}
 	return &f, nil
 }
@@ -52,39 +194,133 @@ func (f *SpatioTemporalFilter) Distance() float64 {
 	return float64(f.distance.Angle() * earthRadiusMeters)
 }
 
+// TTL returns time to live of the index entries.
+func (f *SpatioTemporalFilter) TTL() time.Duration {
+	return f.ttl
+}
+
+// EntityTTL returns time to live of the index entries of the entities, which
+// override the filter TTL.
+func (f *SpatioTemporalFilter) EntityTTL() map[string]time.Duration {
+	ttls := make(map[string]time.Duration, len(f.entityTTL))
+	for entity, ttl := range f.entityTTL {
+		ttls[entity] = ttl
+	}
+	return ttls
+}
+
+// ttlOf returns time to live of the index entries of the entity.
+func (f *SpatioTemporalFilter) ttlOf(entity string) time.Duration {
+	if ttl, ok := f.entityTTL[entity]; ok {
+		return ttl
+	}
+	return f.ttl
+}
+
+// Window returns the mode how duplicates affect the time window of the
+// location.
+func (f *SpatioTemporalFilter) Window() WindowMode {
+	return f.window
+}
+
+// Accuracy returns the mode to combine accuracy of events and the bounds of
+// the distance tolerance in meters.
+func (f *SpatioTemporalFilter) Accuracy() (mode AccuracyMode, min, max float64) {
+	return f.accuracyMode, f.minDistance, f.maxDistance
+}
+
+// Tolerance returns distance tolerance in meters between two events with
+// given accuracies.
+func (f *SpatioTemporalFilter) Tolerance(a, b float64) float64 {
+	if f.accuracyMode == AccuracyIgnore {
+		return f.Distance()
+	}
+	return f.accuracyMode.combine(a, b, f.minDistance, f.maxDistance)
+}
+
+// searchDistance returns the largest distance tolerance between any events in
+// any zone.
+func (f *SpatioTemporalFilter) searchDistance() s1.ChordAngle {
+	d := f.distance
+	if f.accuracyMode != AccuracyIgnore {
+		d = chordAngle(f.maxDistance)
+	}
+	for i := range f.zones {
+		if s := f.search(f.toleranceOf(&f.zones[i])); s > d {
+			d = s
+		}
+	}
+	return d
+}
+
 // Interval returns time tolerance.
 func (f *SpatioTemporalFilter) Interval() time.Duration {
 	return f.interval
 }
 
+// Lateness returns allowed lateness of events and the policy for late events.
+func (f *SpatioTemporalFilter) Lateness() (time.Duration, LatenessPolicy) {
+	return f.lateness, f.latenessPolicy
+}
+
+// MaxFutureSkew returns maximum allowed skew of event time ahead of the server
+// time and the policy for future-dated events.
+func (f *SpatioTemporalFilter) MaxFutureSkew() (time.Duration, SkewPolicy) {
+	return f.maxSkew, f.skewPolicy
+}
+
+// SkewedEvents returns the number of future-dated events, which were clamped
+// and rejected.
+func (f *SpatioTemporalFilter) SkewedEvents() (clamped, rejected uint64) {
+	return atomic.LoadUint64(&f.skewClamped), atomic.LoadUint64(&f.skewRejected)
+}
+
+// Watermark returns the time of the most recent event. Tiers share the
+// watermark of their filter.
+func (f *SpatioTemporalFilter) Watermark() time.Time {
+	if f.parent != nil {
+		return f.parent.Watermark()
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.watermark
+}
+
+// expiryCutoff returns the time, before which locations are too old to match
+// any event within the allowed lateness behind the watermark in any zone.
+func (f *SpatioTemporalFilter) expiryCutoff() time.Time {
+	return f.Watermark().Add(-f.lateness - f.maxInterval)
+}
+
 // Level returns filter cell level.
 func (f *SpatioTemporalFilter) Level() int {
 	return f.level
 }
 
-// IndexedLocations iterates over indexed locations and calls fn with
-// latitude and longitude.
-func (f *SpatioTemporalFilter) IndexedLocations(fn func(lat, lng float64) error) error {
-	return f.db.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		opts.Prefix = []byte{SpatioTemporalKey}
-		opts.PrefetchValues = false
-		iter := txn.NewIterator(opts)
-		defer iter.Close()
-
-		for iter.Rewind(); iter.Valid(); iter.Next() {
-			cellID, t := decodeKey(append([]byte(nil), iter.Item().Key()...))
-
-			// check if location has expired.
-			f.mu.RLock()
-			if f.watermark.Add(-f.interval).After(t) {
-				f.mu.RUnlock()
-				continue
-			}
-			f.mu.RUnlock()
+// IndexedLocations iterates over indexed locations of all entities and calls
+// fn with each location.
+func (f *SpatioTemporalFilter) IndexedLocations(fn func(Location) error) error {
+	return f.indexedLocations(func(string) bool {
+		return true
+	}, fn, encodeTypePrefix(), []byte{BucketKey})
+}
+
+// EntityLocations iterates over indexed locations of the given entity and
+// calls fn with each location. Locations in time buckets are scanned for all
+// entities.
+func (f *SpatioTemporalFilter) EntityLocations(entity string, fn func(Location) error) error {
+	if len(entity) > maxEntityLen {
+		return ErrEntityTooLong
+	}
+	return f.indexedLocations(func(e string) bool {
+		return e == entity
+	}, fn, encodeEntityPrefix(entity), []byte{BucketKey})
+}
 
-			ll := cellID.LatLng()
-			if err := fn(ll.Lat.Degrees(), ll.Lng.Degrees()); err != nil {
+func (f *SpatioTemporalFilter) indexedLocations(match func(entity string) bool, fn func(Location) error, prefixes ...[]byte) error {
+	return f.store.View(func(txn Txn) error {
+		for _, prefix := range prefixes {
+			if err := txn.Scan(prefix, prefixEnd(prefix), f.scanLocations(match, fn)); err != nil {
 				return err
 			}
 		}
@@ -92,131 +328,530 @@ func (f *SpatioTemporalFilter) IndexedLocations(fn func(lat, lng float64) error)
 	})
 }
 
-func (f *SpatioTemporalFilter) Filter(ev Event) (isUnique bool, err error) {
-	err = f.db.Update(func(txn *badger.Txn) error {
-		ll := s2.LatLngFromDegrees(ev.Lat, ev.Lng)
-		if !ll.IsValid() {
-			return fmt.Errorf("filter: invalid coordinates [%v, %v]", ev.Lat, ev.Lng)
+// scanLocations returns Txn.Scan callback, which decodes locations of entities
+// matching the entity and calls fn with each location.
+func (f *SpatioTemporalFilter) scanLocations(match func(entity string) bool, fn func(Location) error) func(Item) error {
+	return func(item Item) error {
+		entity, cellID, t, err := decodeLocationKey(item.Key())
+		if err != nil || !match(entity) {
+			return nil // skip keys in unknown format.
+		}
+
+		// check if location has expired.
+		if f.expiryCutoff().After(t) {
+			return nil
+		}
+
+		loc, err := newLocation(item, entity, cellID, t)
+		if err != nil {
+			return err
+		}
+		return fn(*loc)
+	}
+}
+
+// Filter processes event and returns the result. Event is late, if it is
+// older than the allowed lateness behind the watermark. Late events are handled
+// according to the lateness policy. Events within exclusion zones are unique
+// and are neither checked nor indexed.
+func (f *SpatioTemporalFilter) Filter(ev Event) (Result, error) {
+	if res, ok := f.exclude(ev); ok {
+		return res, nil
+	}
+	res, advanced, err := f.admit(&ev)
+	if err != nil || res.Routed {
+		return res, err
+	}
+
+	defer f.lock(ev)()
+
+	admitted := res
+	err = f.update(func(txn Txn) error {
+		res, err = f.process(txn, ev, admitted)
+		return err
+	})
+	if err != nil {
+		return res, err
+	}
+	f.emitDwell(res)
+	if advanced {
+		return res, f.storeWatermark()
+	}
+	return res, nil
+}
+
+// FilterBatch processes events in time order and returns results in the order
+// of events. Events are deduplicated against the index and against each other
+// within as few transactions as possible. Errors caused by individual events
+// are returned in results. If an error is returned, events processed in the
+// already committed transactions remain indexed.
+func (f *SpatioTemporalFilter) FilterBatch(evs []Event) ([]BatchResult, error) {
+	order := make([]int, len(evs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return evs[order[i]].Time.Before(evs[order[j]].Time)
+	})
+
+	// events are admitted once, before any transaction is run, because
+	// transactions may be retried.
+	type admittedEvent struct {
+		i   int
+		ev  Event
+		res Result
+	}
+	results := make([]BatchResult, len(evs))
+	admitted := make([]admittedEvent, 0, len(evs))
+	batch := make([]Event, 0, len(evs))
+	var advanced bool
+	for _, i := range order {
+		ev := evs[i]
+		if res, ok := f.exclude(ev); ok {
+			results[i] = BatchResult{Result: res}
+			continue
+		}
+		res, adv, err := f.admit(&ev)
+		if err != nil || res.Routed {
+			results[i] = BatchResult{Result: res, Err: err}
+			continue
+		}
+		advanced = advanced || adv
+		admitted = append(admitted, admittedEvent{i: i, ev: ev, res: res})
+		batch = append(batch, ev)
+	}
+
+	defer f.lock(batch...)()
+
+	err := inBatches(f.update, len(admitted), func(txn Txn, i int) error {
+		a := admitted[i]
+		res, err := f.process(txn, a.ev, a.res)
+		var speedErr *SpeedError
+		if errors.As(err, &speedErr) {
+			// rejected event has not written anything.
+			results[a.i] = BatchResult{Result: a.res, Err: err}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		results[a.i] = BatchResult{Result: res}
+		return nil
+	}, func(start, end int) {
+		for _, a := range admitted[start:end] {
+			f.emitDwell(results[a.i].Result)
+		}
+	})
+	if err != nil {
+		return results, err
+	}
+	if advanced {
+		return results, f.storeWatermark()
+	}
+	return results, nil
+}
+
+// Contains checks whether event is a duplicate of an indexed location without
+// modifying the index or the watermark. Result is the one Filter would return,
+// except that late events are neither rejected nor routed and that neither
+// speed nor dwells are checked.
+func (f *SpatioTemporalFilter) Contains(ev Event) (Result, error) {
+	var res Result
+	if err := validate(ev); err != nil {
+		return res, err
+	}
+	if res, ok := f.exclude(ev); ok {
+		return res, nil
+	}
+
+	f.mu.RLock()
+	res.Late = ev.Time.Before(f.watermark.Add(-f.lateness))
+	f.mu.RUnlock()
+
+	err := f.store.View(func(txn Txn) error {
+		var err error
+		if res, err = f.find(txn, ev, res); err != nil {
+			return err
 		}
+		res, err = f.findTiers(txn, ev, res)
+		return err
+	})
+	return res, err
+}
 
-		// watermark holds the time of the most recent event.
-		f.mu.Lock()
-		if ev.Time.After(f.watermark) {
-			f.watermark = ev.Time
+// admit validates event and checks it against the server time and the
+// watermark, which it moves forward. Time of future-dated event may be clamped.
+// It returns true, if event has moved the watermark.
+func (f *SpatioTemporalFilter) admit(ev *Event) (res Result, advanced bool, err error) {
+	if err := validate(*ev); err != nil {
+		return res, false, err
+	}
+
+	// future-dated events are checked against the server time, so that a
+	// single client with the wrong clock cannot move the watermark ahead.
+	if f.maxSkew > 0 {
+		now := f.now()
+		if limit := now.Add(f.maxSkew); ev.Time.After(limit) {
+			if f.skewPolicy == SkewReject {
+				atomic.AddUint64(&f.skewRejected, 1)
+				return res, false, &FutureEventError{
+					Time:    ev.Time,
+					Now:     now,
+					MaxSkew: f.maxSkew,
+				}
+			}
+			atomic.AddUint64(&f.skewClamped, 1)
+			ev.Time = limit
+		}
+	}
+
+	// watermark holds the time of the most recent event. Event is checked for
+	// lateness before it can move the watermark.
+	f.mu.Lock()
+	watermark := f.watermark
+	res.Late = ev.Time.Before(watermark.Add(-f.lateness))
+	advanced = ev.Time.After(f.watermark)
+	if advanced {
+		f.watermark = ev.Time
+	}
+	f.mu.Unlock()
+
+	if res.Late {
+		switch f.latenessPolicy {
+		case LatenessReject:
+			return res, false, &LateEventError{
+				Time:      ev.Time,
+				Watermark: watermark,
+				Lateness:  f.lateness,
+			}
+		case LatenessSideChannel:
+			f.lateEvents(*ev)
+			res.Routed = true
 		}
-		f.mu.Unlock()
+	}
+	return res, advanced, nil
+}
 
-		// first pass, is the scan for any earlier events.
-		pt := s2.PointFromLatLng(ll)
-		for _, id := range f.Cells(ll) {
-			if hasMatch := f.match(txn, id, pt); hasMatch {
-				return nil // found match
+// process checks speed of the event entity, filters event in the transaction
+// and tracks the stay of its entity, if these are enabled. Event, which implies
+// impossible speed, is either rejected before anything is written or flagged
+// and left out of the index.
+func (f *SpatioTemporalFilter) process(txn Txn, ev Event, res Result) (Result, error) {
+	if f.maxSpeed > 0 {
+		speed, ok, err := f.checkSpeed(txn, ev)
+		if err != nil {
+			return res, err
+		}
+		if !ok {
+			if f.speedPolicy == SpeedReject {
+				return res, &SpeedError{Speed: speed, MaxSpeed: f.maxSpeed}
 			}
+			res.Flagged = true
+			res.Speed = speed
+			return res, nil
 		}
-		isUnique = true
+	}
+
+	res, err := f.filter(txn, ev, res)
+	if err != nil {
+		return res, err
+	}
+	if res, err = f.filterTiers(txn, ev, res); err != nil || f.minDwell == 0 {
+		return res, err
+	}
+	res.Dwell, err = f.dwell(txn, ev)
+	return res, err
+}
+
+// emitDwell calls the dwell events function with the dwell event of the
+// committed result.
+func (f *SpatioTemporalFilter) emitDwell(res Result) {
+	if res.Dwell != nil && f.dwellEvents != nil {
+		f.dwellEvents(*res.Dwell)
+	}
+}
+
+// validate checks that event can be filtered.
+func validate(ev Event) error {
+	if !s2.LatLngFromDegrees(ev.Lat, ev.Lng).IsValid() {
+		return fmt.Errorf("filter: invalid coordinates [%v, %v]", ev.Lat, ev.Lng)
+	}
+	if len(ev.Entity) > maxEntityLen {
+		return ErrEntityTooLong
+	}
+	if ev.Accuracy < 0 || math.IsNaN(ev.Accuracy) {
+		return fmt.Errorf("filter: invalid accuracy %v", ev.Accuracy)
+	}
+	return nil
+}
+
+// filter scans the index in the transaction for the earlier events matching
+// given event and stores the event in the index, if none found.
+func (f *SpatioTemporalFilter) filter(txn Txn, ev Event, res Result) (Result, error) {
+	// first pass, is the scan for any earlier events.
+	res, err := f.find(txn, ev, res)
+	if err != nil {
+		return res, err
+	}
+	if loc := res.Match; loc != nil {
+		// duplicate is merged into the matched location, which keeps its
+		// expiry, unless the window slides.
+		loc.merge(ev)
+		if f.window == WindowSliding && ev.Time.After(loc.Time) {
+			return res, f.slide(txn, loc, ev.Time)
+		}
+		return res, f.put(txn, loc.key, loc.value(), ttlUntil(loc.expiresAt, f.now()))
+	}
+
+	// second pass, is storing given event in the database index, if no
+	// earlier events found. Entry is created with TTL to satisfy temporal
+	// requirement.
+	ll := s2.LatLngFromDegrees(ev.Lat, ev.Lng)
+	key := f.locationKey(ev.Entity, s2.CellIDFromLatLng(ll), ev.Time, atomic.AddUint32(&f.seq, 1))
+	return res, f.put(txn, key, value{
+		ID:         ev.ID,
+		Accuracy:   ev.Accuracy,
+		Attributes: ev.Attributes,
+		Count:      1,
+		FirstSeen:  ev.Time,
+		LastSeen:   ev.Time,
+		Centroid:   [2]float64{ev.Lat, ev.Lng},
+	}, f.locationTTL(ev.Entity))
+}
+
+// find scans the index in the transaction for the earlier events matching
+// given event. Result is unique, if none found.
+func (f *SpatioTemporalFilter) find(txn Txn, ev Event, res Result) (Result, error) {
+	ll := s2.LatLngFromDegrees(ev.Lat, ev.Lng)
+	pt := s2.PointFromLatLng(ll)
+	tol := f.toleranceAt(ll)
+	if tol.zone != nil {
+		res.Zone = tol.zone.Name
+	}
+	for _, id := range f.cells(ll, tol) {
+		loc, err := f.match(txn, ev, id, pt, tol)
+		if err != nil {
+			return res, err
+		}
+		if loc != nil {
+			res.Match = loc
+			res.Distance = float64(pt.Distance(loc.CellID.Point()) * earthRadiusMeters)
+			res.TimeDelta = ev.Time.Sub(loc.Time)
+			res.Tolerance = f.combine(tol, ev.Accuracy, loc.Accuracy)
+			return res, nil
+		}
+	}
+	res.Unique = true
+	return res, nil
+}
 
-		// second pass, is storing given event in the database index, if no
-		// earlier events found. Entry is created with TTL to satisfy temporal
-		// requirement.
-		key := encodeKey(s2.CellIDFromLatLng(ll), ev.Time)
-		entry := badger.NewEntry(key, nil)
-		return txn.SetEntry(entry.WithTTL(locationsTTL))
+// slide moves the location to the given time and refreshes its TTL. Location
+// is re-indexed, because time is a part of the index key.
+func (f *SpatioTemporalFilter) slide(txn Txn, loc *Location, t time.Time) error {
+	key := f.locationKey(loc.Entity, loc.CellID, t, atomic.AddUint32(&f.seq, 1))
+	if err := f.put(txn, key, loc.value(), f.locationTTL(loc.Entity)); err != nil {
+		return err
+	}
+	if err := txn.Delete(loc.key); err != nil {
+		return err
+	}
+	loc.Time = t
+	loc.key = key
+	loc.expiresAt = time.Time{}
+	if ttl := f.locationTTL(loc.Entity); ttl > 0 {
+		loc.expiresAt = f.now().Add(ttl)
+	}
+	return nil
+}
+
+// put encodes and stores the value of the index entry in the transaction.
+func (f *SpatioTemporalFilter) put(txn Txn, key []byte, v value, ttl time.Duration) error {
+	val, err := encodeValue(v)
+	if err != nil {
+		return err
+	}
+	return txn.Put(key, val, ttl)
+}
+
+// loadWatermark loads persisted watermark from the database, so that restart
+// does not reset it.
+func (f *SpatioTemporalFilter) loadWatermark() error {
+	return f.store.View(func(txn Txn) error {
+		val, err := txn.Get([]byte{WatermarkKey})
+		if errors.Is(err, ErrKeyNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(val) != timestampLen {
+			return errors.New("filter: invalid watermark")
+		}
+		f.watermark = time.Unix(0, int64(binary.BigEndian.Uint64(val)))
+		f.stored = f.watermark
+		return nil
 	})
-	return
+}
+
+// storeWatermark persists current watermark in its own transaction, so that
+// transactions of events in unrelated areas do not conflict on it. Writes are
+// serialised and skipped, unless the watermark is more recent than the one
+// persisted last.
+func (f *SpatioTemporalFilter) storeWatermark() error {
+	f.storeMu.Lock()
+	defer f.storeMu.Unlock()
+
+	watermark := f.Watermark()
+	if !watermark.After(f.stored) {
+		return nil
+	}
+	buf := make([]byte, timestampLen)
+	binary.BigEndian.PutUint64(buf, uint64(watermark.UnixNano()))
+	err := f.update(func(txn Txn) error {
+		return txn.Put([]byte{WatermarkKey}, buf, 0)
+	})
+	if err == nil {
+		f.stored = watermark
+	}
+	return err
 }
 
 // Cells returns s2.CellUnion of cells to search for earlier indexed locations.
 func (f *SpatioTemporalFilter) Cells(ll s2.LatLng) s2.CellUnion {
-	// Cell 0 is where the current event LatLng belongs to. Cell edge length is
-	// approximately equals to the distance tolerance. If event's LatLng is
-	// close to the cell edge, then earlier event's coordinates can be in the
-	// cell 0 or one of the neighbour Cells. Hence, all 9 Cells must be checked
-	// for earlier events. CellID is used as a key prefix.
-	//
-	// +---+---+---+
-	// | 1 | 2 | 3 |
-	// +---+---+---+
-	// | 4 | 0 | 5 |
-	// +---+---+---+
-	// | 6 | 7 | 8 |
-	// +---+---+---+
-
-	cellID := s2.CellIDFromLatLng(ll).Parent(f.level)
-	cells := make([]s2.CellID, 9)
-	cells[0] = cellID
-	for i, cellID := range cellID.AllNeighbors(f.level) {
-		cells[i+1] = cellID
-	}
-	return cells
-}
-
-// match iterates over records with the prefix from cellID and compares distance
-// between given s2.LatLng and coordinates on the index key. If distance is
-// within distance (argument) is returns true.
-func (f *SpatioTemporalFilter) match(txn *badger.Txn, cellID s2.CellID, pt s2.Point) bool {
-	opts := badger.DefaultIteratorOptions
-	opts.PrefetchValues = false
-	opts.Prefix = []byte{SpatioTemporalKey}
-	iter := txn.NewIterator(opts)
-	defer iter.Close()
-
-	minRange := encodePrefix(cellID.RangeMin())
-	maxRange := encodePrefix(cellID.RangeMax())
-
-	for iter.Seek(minRange); iter.Valid() && bytes.Compare(iter.Item().Key(), maxRange) <= 0; iter.Next() {
-		key := iter.Item().Key()
-		cellID, t := decodeKey(key)
+	return f.cells(ll, f.toleranceAt(ll))
+}
 
-		// check if location has expired.
-		f.mu.RLock()
-		if f.watermark.Add(-f.interval).After(t) {
-			f.mu.RUnlock()
-			_ = txn.Delete(key) // delete expired location.
-			continue
+func (f *SpatioTemporalFilter) cells(ll s2.LatLng, tol tolerance) s2.CellUnion {
+	// Earlier event matches, if it is within the cap of the distance tolerance
+	// around the event's LatLng. Cells at the filter level, which cover that
+	// cap, contain every location within the distance tolerance regardless of
+	// how close the event is to the cell edges or cube face corners, where
+	// cells have fewer or distorted neighbours. Cap is sized to the largest
+	// tolerance allowed by accuracy of events in the zone of the event. Cell
+	// width is at least the largest tolerance in any zone, so covering is
+	// usually 4 to 9 Cells. CellID is used as a key range.
+	c := s2.CapFromCenterChordAngle(s2.PointFromLatLng(ll), f.search(tol))
+	rc := s2.RegionCoverer{
+		MinLevel: f.level,
+		MaxLevel: f.level,
+		MaxCells: maxCoveringCells,
+	}
+	return rc.Covering(c)
+}
+
+// match iterates over records of the entity with the prefix from cellID and
+// compares time and distance between given event and the time and coordinates
+// on the index key. If both are within tolerance it returns matched location.
+func (f *SpatioTemporalFilter) match(txn Txn, ev Event, cellID s2.CellID, pt s2.Point, tol tolerance) (*Location, error) {
+	var loc *Location
+	scan := func(item Item) error {
+		key := item.Key()
+		_, cellID, t, err := decodeLocationKey(key)
+		if err != nil {
+			return nil // skip keys in unknown format.
 		}
-		f.mu.RUnlock()
 
-		if s2.CompareDistance(pt, cellID.Point(), f.distance) <= 0 {
-			return true
+		// location can only match events which are within time tolerance on
+		// either side of it.
+		if !withinInterval(ev.Time, t, tol.interval) {
+			// check if location has expired, that is it is too old for both
+			// the event and any event within the allowed lateness in any
+			// zone. Locations in time buckets are left to be dropped with
+			// their bucket.
+			expired := f.expiryCutoff().After(t) && ev.Time.Add(-f.maxInterval).After(t)
+			if expired && f.bucket == 0 {
+				_ = txn.Delete(append([]byte(nil), key...)) // delete expired location, unless read-only.
+			}
+			return nil
+		}
+
+		// location is checked against the largest tolerance first, so that
+		// value is only read for the nearby locations.
+		if s2.CompareDistance(pt, cellID.Point(), f.search(tol)) > 0 {
+			return nil
+		}
+		l, err := newLocation(item, ev.Entity, cellID, t)
+		if err != nil {
+			return err
+		}
+		if s2.CompareDistance(pt, cellID.Point(), chordAngle(f.combine(tol, ev.Accuracy, l.Accuracy))) <= 0 {
+			loc = l
+			return errStopScan
+		}
+		return nil
+	}
+
+	for _, r := range f.locationRanges(ev.Entity, cellID, ev.Time) {
+		err := txn.Scan(r[0], r[1], scan)
+		if errors.Is(err, errStopScan) {
+			return loc, nil
+		}
+		if err != nil {
+			return nil, err
 		}
 	}
-	return false
+	return nil, nil
 }
 
-const (
-	s2CellIDLen  = 8
-	timestampLen = 8
-)
+// newLocation returns Location from the decoded key and the value of the
+// database index entry.
+func newLocation(item Item, entity string, cellID s2.CellID, t time.Time) (*Location, error) {
+	val, err := item.Value()
+	if err != nil {
+		return nil, err
+	}
+	v, err := decodeValue(val)
+	if err != nil {
+		return nil, err
+	}
+	loc := Location{
+		ID:         v.ID,
+		Entity:     entity,
+		CellID:     cellID,
+		Time:       t,
+		Accuracy:   v.Accuracy,
+		Attributes: v.Attributes,
+		Count:      v.Count,
+		FirstSeen:  v.FirstSeen,
+		LastSeen:   v.LastSeen,
+		Centroid:   s2.LatLngFromDegrees(v.Centroid[0], v.Centroid[1]),
+		key:        append([]byte(nil), item.Key()...),
+		expiresAt:  item.ExpiresAt(),
+	}
+	if loc.Count == 0 {
+		// location has no aggregates yet.
+		loc.Count = 1
+		loc.FirstSeen = t
+		loc.LastSeen = t
+		loc.Centroid = cellID.LatLng()
+	}
+	return &loc, nil
+}
+
+// withinInterval returns true, if absolute difference between a and b is
+// within time tolerance.
+func withinInterval(a, b time.Time, interval time.Duration) bool {
+	d := a.Sub(b)
+	if d < 0 {
+		d = -d
+	}
+	return d <= interval
+}
+
+// chordAngle converts distance in meters into s1.ChordAngle.
+func chordAngle(distance float64) s1.ChordAngle {
+	return s1.ChordAngleFromAngle(s1.Angle(distance / earthRadiusMeters))
+}
 
-// encodeKey takes latitude and longitude and encodes them into a key, which is
-// used in the database index.
-// Key format is:
-// - 1 byte, key type;
-// - 8 bytes, s2.CellID, always indexed at the maximum level;
-// - 8 bytes, UNIX timestamp.
-func encodeKey(id s2.CellID, t time.Time) []byte {
-	buf := make([]byte, keyLen+s2CellIDLen+timestampLen)
-	buf[0] = SpatioTemporalKey
-	binary.BigEndian.PutUint64(buf[keyLen:], uint64(id))
-	binary.BigEndian.PutUint64(buf[keyLen+s2CellIDLen:], uint64(t.Unix()))
-	return buf
-}
-
-// encodeKey takes latitude and longitude and encodes them into a key, which is
-// used in the database index.
-// Key format is:
-// - 1 byte, key type;
-// - 8 bytes, s2.CellID, always indexed at the maximum level.
-func encodePrefix(id s2.CellID) []byte {
-	buf := make([]byte, keyLen+s2CellIDLen+timestampLen)
-	buf[0] = SpatioTemporalKey
-	binary.BigEndian.PutUint64(buf[keyLen:], uint64(id))
-	return buf
-}
-
-// decodeKey decodes given slice of bytes (database index key) into s2.LatLng.
-func decodeKey(p []byte) (s2.CellID, time.Time) {
-	id := binary.BigEndian.Uint64(p[keyLen:])
-	ts := binary.BigEndian.Uint64(p[keyLen+s2CellIDLen:])
-	return s2.CellID(id), time.Unix(int64(ts), 0)
+// ttlUntil returns the time to live of the key, which expires at given time,
+// or zero if it never expires.
+func ttlUntil(expiresAt, now time.Time) time.Duration {
+	if expiresAt.IsZero() {
+		return 0
+	}
+	if ttl := expiresAt.Sub(now); ttl > 0 {
+		return ttl
+	}
+	// key expires within the transaction, but must not become eternal.
+	return time.Nanosecond
 }
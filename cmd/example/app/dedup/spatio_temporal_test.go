@@ -0,0 +1,169 @@
+package dedup
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/golang/geo/s1"
+	"github.com/golang/geo/s2"
+)
+
+// cubeCornerLat is the latitude of the cube face corners in degrees.
+var cubeCornerLat = math.Atan(1/math.Sqrt2) * 180 / math.Pi
+
+// TestCellsCoverTolerance checks cells against a brute-force search: every
+// leaf cell, which centre is within the distance tolerance of the event, must
+// be covered, so that no earlier location is missed.
+func TestCellsCoverTolerance(t *testing.T) {
+	tests := []struct {
+		name     string
+		distance float64
+
+		// finer is the number of levels the filter level is made finer by,
+		// so that distance tolerance is larger than the cell edge.
+		finer int
+	}{
+		{name: "10m", distance: 10},
+		{name: "100m", distance: 100},
+		{name: "5km", distance: 5000},
+		{name: "100km", distance: 100000},
+		{name: "100m finer cells", distance: 100, finer: 2},
+		{name: "5km finer cells", distance: 5000, finer: 3},
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	centers := testCenters(rnd, 200)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := NewSpatioTemporalFilter(NewMemoryStore(), tt.distance, time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f := filter.(*SpatioTemporalFilter)
+			f.level += tt.finer
+
+			tolerance := chordAngle(tt.distance)
+			for _, ll := range centers {
+				cells := f.Cells(ll)
+				center := s2.PointFromLatLng(ll)
+				for i := 0; i < 200; i++ {
+					id := s2.CellIDFromLatLng(s2.LatLngFromPoint(testNearby(rnd, center, tt.distance)))
+					if s2.CompareDistance(center, id.Point(), tolerance) > 0 {
+						continue // brute-force check, location is too far.
+					}
+					if !cells.ContainsCellID(id) {
+						t.Fatalf("location %v within %vm of %v is not covered by %d cells",
+							id.LatLng(), tt.distance, ll, len(cells))
+					}
+				}
+			}
+		})
+	}
+}
+
+// testCenters returns random event locations and the locations next to the
+// cube face corners, poles and the antimeridian, where cells are distorted.
+func testCenters(rnd *rand.Rand, n int) []s2.LatLng {
+	var centers []s2.LatLng
+	for _, lat := range []float64{cubeCornerLat, -cubeCornerLat} {
+		for _, lng := range []float64{-135, -45, 45, 135} {
+			centers = append(centers, s2.LatLngFromDegrees(lat, lng))
+		}
+	}
+	centers = append(centers,
+		s2.LatLngFromDegrees(90, 0),
+		s2.LatLngFromDegrees(-90, 0),
+		s2.LatLngFromDegrees(0, 180),
+		s2.LatLngFromDegrees(0, 0),
+	)
+	for i := 0; i < n; i++ {
+		centers = append(centers, s2.LatLngFromPoint(testRandomPoint(rnd)))
+	}
+	return centers
+}
+
+// testNearby returns a random point up to 1.5 times the distance in meters
+// away from the center.
+func testNearby(rnd *rand.Rand, center s2.Point, distance float64) s2.Point {
+	d := s1.Angle(rnd.Float64() * 1.5 * distance / earthRadiusMeters)
+	return s2.InterpolateAtDistance(d, center, testRandomPoint(rnd))
+}
+
+// testRandomPoint returns a point uniformly distributed over the sphere.
+func testRandomPoint(rnd *rand.Rand) s2.Point {
+	lat := math.Asin(2*rnd.Float64() - 1)
+	lng := (2*rnd.Float64() - 1) * math.Pi
+	return s2.PointFromLatLng(s2.LatLng{Lat: s1.Angle(lat), Lng: s1.Angle(lng)})
+}
+
+func TestEntityTTL(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		wantErr bool
+	}{
+		{name: "shorter", opts: []Option{WithTTL(time.Hour, 0), WithEntityTTL(map[string]time.Duration{"a": time.Minute})}},
+		{name: "longer", opts: []Option{WithTTL(time.Hour, 0), WithEntityTTL(map[string]time.Duration{"a": 2 * time.Hour})}, wantErr: true},
+		{name: "shorter than tolerance", opts: []Option{WithEntityTTL(map[string]time.Duration{"a": time.Second})}, wantErr: true},
+		{name: "time buckets", opts: []Option{WithTimeBuckets(time.Hour), WithEntityTTL(map[string]time.Duration{"a": time.Minute})}, wantErr: true},
+		{
+			// entity TTL is capped at the shorter TTL of the tier.
+			name: "tiers",
+			opts: []Option{
+				WithTTL(time.Hour, 0),
+				WithEntityTTL(map[string]time.Duration{"a": time.Hour}),
+				WithTiers(TierAny, Tier{Distance: 10, Interval: 10 * time.Second}),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewSpatioTemporalFilter(NewMemoryStore(), 100, time.Minute, tt.opts...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFilterBatch(t *testing.T) {
+	start := time.Now().Truncate(time.Hour)
+	evs := []Event{
+		{Entity: "a", Time: start.Add(10 * time.Second), Lat: 10, Lng: 10},
+		{Entity: "a", Time: start, Lat: 10, Lng: 10},
+		{Entity: "b", Time: start, Lat: 10, Lng: 10},
+		{Entity: "a", Time: start.Add(5 * time.Minute), Lat: 10, Lng: 10},
+		{Entity: "a", Time: start.Add(20 * time.Second), Lat: 20, Lng: 20},
+		{Entity: "b", Time: start.Add(30 * time.Second), Lat: 10, Lng: 10.0001},
+	}
+	want := []bool{false, true, true, true, true, false}
+
+	for _, limit := range []int{0, 2, 3} {
+		t.Run(fmt.Sprintf("limit %d", limit), func(t *testing.T) {
+			var store Store = NewMemoryStore()
+			if limit > 0 {
+				// transactions are full before all the events are processed.
+				store = &limitStore{Store: store, limit: limit}
+			}
+			filter, err := NewSpatioTemporalFilter(store, 100, time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			results, err := filter.(*SpatioTemporalFilter).FilterBatch(evs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, res := range results {
+				if res.Err != nil {
+					t.Fatalf("event %d: %v", i, res.Err)
+				}
+				if res.Unique != want[i] {
+					t.Fatalf("event %d: got unique %v, want %v", i, res.Unique, want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package dedup
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/golang/geo/s2"
+)
+
+const (
+	// SpeedReject rejects events, which imply impossible speed, with
+	// SpeedError.
+	SpeedReject SpeedPolicy = iota
+
+	// SpeedFlag flags events, which imply impossible speed, in the result
+	// without filtering them.
+	SpeedFlag
+)
+
+// SpeedPolicy defines how filter handles events, which imply speed of the
+// entity above the maximum since its previous accepted event.
+type SpeedPolicy int
+
+// ParseSpeedPolicy returns SpeedPolicy from its string representation.
+func ParseSpeedPolicy(s string) (SpeedPolicy, error) {
+	switch s {
+	case "reject":
+		return SpeedReject, nil
+	case "flag":
+		return SpeedFlag, nil
+	}
+	return 0, fmt.Errorf("filter: unknown speed policy %q", s)
+}
+
+func (p SpeedPolicy) String() string {
+	switch p {
+	case SpeedReject:
+		return "reject"
+	case SpeedFlag:
+		return "flag"
+	}
+	return fmt.Sprintf("SpeedPolicy(%d)", int(p))
+}
+
+// SpeedError is returned when event is rejected, because it implies speed of
+// the entity above the maximum since its previous accepted event.
+type SpeedError struct {
+	// Speed is the implied speed in meters per second.
+	Speed    float64
+	MaxSpeed float64
+}
+
+func (e *SpeedError) Error() string {
+	return fmt.Sprintf("filter: implied speed %.2f m/s exceeds the maximum speed %.2f m/s", e.Speed, e.MaxSpeed)
+}
+
+// trackValue is the payload of the entry, which holds the previous accepted
+// event of the entity.
+type trackValue struct {
+	Lat      float64   `json:"lat"`
+	Lng      float64   `json:"lng"`
+	Accuracy float64   `json:"accuracy,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// MaxSpeed returns maximum speed of entities in meters per second and the
+// policy for events, which imply higher speed. Zero speed means that the check
+// is disabled.
+func (f *SpatioTemporalFilter) MaxSpeed() (float64, SpeedPolicy) {
+	return f.maxSpeed, f.speedPolicy
+}
+
+// checkSpeed compares event with the previous accepted event of the entity in
+// the transaction. It returns implied speed in meters per second and false,
+// if it exceeds the maximum speed. Otherwise event becomes the previous
+// accepted event, unless it is older than that.
+func (f *SpatioTemporalFilter) checkSpeed(txn Txn, ev Event) (float64, bool, error) {
+	key := encodeTrackKey(ev.Entity)
+
+	val, err := txn.Get(key)
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return 0, false, err
+	}
+	if err == nil {
+		v, err := decodeTrackValue(val)
+		if err != nil {
+			return 0, false, err
+		}
+		if speed := f.impliedSpeed(ev, v); speed > f.maxSpeed {
+			return speed, false, nil
+		}
+		if ev.Time.Before(v.Time) {
+			return 0, true, nil
+		}
+	}
+
+	val, err = encodeJSONValue(trackValue{
+		Lat:      ev.Lat,
+		Lng:      ev.Lng,
+		Accuracy: ev.Accuracy,
+		Time:     ev.Time,
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	return 0, true, txn.Put(key, val, f.ttlOf(ev.Entity))
+}
+
+// impliedSpeed returns speed in meters per second between event and the
+// previous event. Events within the distance tolerance of each other imply
+// zero speed, so that positioning noise is not mistaken for movement. Time
+// between events is at least a second, which is the usual resolution of
+// positioning fixes.
+func (f *SpatioTemporalFilter) impliedSpeed(ev Event, prev trackValue) float64 {
+	a := s2.LatLngFromDegrees(ev.Lat, ev.Lng)
+	b := s2.LatLngFromDegrees(prev.Lat, prev.Lng)
+	d := float64(a.Distance(b)) * earthRadiusMeters
+	if d <= f.combine(f.toleranceAt(a), ev.Accuracy, prev.Accuracy) {
+		return 0
+	}
+	return d / math.Max(math.Abs(ev.Time.Sub(prev.Time).Seconds()), 1)
+}
+
+// encodeTrackKey encodes entity into a key of its previous accepted event.
+// Key format is:
+// - 1 byte, key type;
+// - 2 bytes, entity length;
+// - N bytes, entity.
+func encodeTrackKey(entity string) []byte {
+	buf := make([]byte, keyLen+entityLen+len(entity))
+	buf[0] = TrackKey
+	binary.BigEndian.PutUint16(buf[keyLen:], uint16(len(entity)))
+	copy(buf[keyLen+entityLen:], entity)
+	return buf
+}
+
+// decodeTrackKey decodes given slice of bytes (key of the previous accepted
+// event) into entity.
+func decodeTrackKey(p []byte) (string, error) {
+	if len(p) < keyLen+entityLen || p[0] != TrackKey {
+		return "", errInvalidKey
+	}
+	m := keyLen + entityLen + int(binary.BigEndian.Uint16(p[keyLen:]))
+	if len(p) != m {
+		return "", errInvalidKey
+	}
+	return string(p[keyLen+entityLen : m]), nil
+}
+
+// decodeTrackValue decodes given slice of bytes into the payload of the
+// previous accepted event.
+func decodeTrackValue(p []byte) (trackValue, error) {
+	var v trackValue
+	err := decodeJSONValue(p, &v)
+	return v, err
+}
@@ -0,0 +1,170 @@
+package dedup
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrKeyNotFound is returned by Txn.Get when the key is not in the store.
+	ErrKeyNotFound = errors.New("store: key not found")
+
+	// ErrTxnTooBig is returned by Txn.Put and Txn.Delete when the transaction
+	// cannot hold any more writes.
+	ErrTxnTooBig = errors.New("store: transaction is too big")
+
+	// ErrTxnConflict is returned by Store.Update when the transaction conflicts
+	// with a concurrent transaction and may be retried.
+	ErrTxnConflict = errors.New("store: transaction conflict")
+
+	// errTxnFull discards the transaction, which cannot hold all the writes
+	// of an item.
+	errTxnFull = errors.New("store: transaction is full")
+)
+
+// Store is a transactional ordered key-value storage of the deduplication
+// index.
+type Store interface {
+	// View runs fn in a read-only transaction.
+	View(fn func(Txn) error) error
+
+	// Update runs fn in a read-write transaction, which is committed if fn
+	// returns nil and discarded otherwise.
+	Update(fn func(Txn) error) error
+}
+
+// GarbageCollector is implemented by stores, which do not reclaim space of
+// deleted and expired keys on their own.
+type GarbageCollector interface {
+	// CollectGarbage reclaims space and returns the number of reclaimed
+	// storage units, which are specific to the store.
+	CollectGarbage() (int, error)
+}
+
+// PrefixDropper is implemented by stores, which delete all the keys with the
+// prefix faster than one by one.
+type PrefixDropper interface {
+	// DropPrefix deletes all the keys with any of the prefixes. Concurrent
+	// read-write transactions wait until it returns, so prefixes are dropped
+	// together rather than by separate calls.
+	DropPrefix(prefixes ...[]byte) error
+}
+
+// Txn is a Store transaction. Writes of read-write transaction are visible to
+// its reads.
+type Txn interface {
+	// Get returns a copy of the value of the key or ErrKeyNotFound.
+	Get(key []byte) ([]byte, error)
+
+	// Scan calls fn for each key in the range [start, end) in the key order.
+	// Nil end scans to the end of the keyspace. Scan stops and returns the
+	// error, if fn returns one. Keys may be deleted by fn during the scan.
+	Scan(start, end []byte, fn func(Item) error) error
+
+	// Put sets the value of the key, which expires after ttl. Zero ttl never
+	// expires.
+	Put(key, value []byte, ttl time.Duration) error
+
+	// Delete deletes the key.
+	Delete(key []byte) error
+}
+
+// Item is a key-value pair passed to Txn.Scan callback. It is only valid
+// until the callback returns.
+type Item interface {
+	// Key returns the key.
+	Key() []byte
+
+	// Value returns a copy of the value.
+	Value() ([]byte, error)
+
+	// ExpiresAt returns the time, when the key expires, or zero time if it
+	// never expires.
+	ExpiresAt() time.Time
+}
+
+// inBatches runs fn for each of n items in as few read-write transactions run
+// by update as possible and calls committed with the range of items [start,
+// end) of each committed transaction. Transaction, which cannot hold all the
+// writes of an item, is discarded and run again over the items before it, so
+// that no item is committed partially, and the rest of items are processed in
+// the next transaction. Item, which does not fit into an empty transaction,
+// fails with ErrTxnTooBig. Committed may be nil.
+func inBatches(update func(func(Txn) error) error, n int, fn func(txn Txn, i int) error, committed func(start, end int)) error {
+	for start := 0; start < n; {
+		end := n
+		for {
+			var i int
+			err := update(func(txn Txn) error {
+				for i = start; i < end; i++ {
+					err := fn(txn, i)
+					if errors.Is(err, ErrTxnTooBig) && i > start {
+						return errTxnFull
+					}
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if errors.Is(err, errTxnFull) {
+				end = i
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			break
+		}
+		if committed != nil {
+			committed(start, end)
+		}
+		start = end
+	}
+	return nil
+}
+
+// scanPrefixes calls fn for each distinct key prefix in the range [start, end)
+// in the key order, until fn returns false. Function prefixLen returns the
+// length of the prefix of the key or zero, if the key has none. Scan seeks
+// from one prefix to the next one rather than iterating over all the keys.
+func scanPrefixes(txn Txn, start, end []byte, prefixLen func(key []byte) int, fn func(prefix []byte) bool) error {
+	for start != nil {
+		var prefix, key []byte
+		err := txn.Scan(start, end, func(item Item) error {
+			key = append([]byte(nil), item.Key()...)
+			if n := prefixLen(key); n > 0 {
+				prefix = key[:n:n]
+			}
+			return errStopScan
+		})
+		if err != nil && !errors.Is(err, errStopScan) {
+			return err
+		}
+		switch {
+		case key == nil:
+			return nil
+		case prefix == nil:
+			// key without a prefix is skipped.
+			start = append(key, 0)
+		case !fn(prefix):
+			return nil
+		default:
+			start = prefixEnd(prefix)
+		}
+	}
+	return nil
+}
+
+// prefixEnd returns the smallest key, which is greater than all the keys
+// starting with the prefix, or nil if there is no such key.
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
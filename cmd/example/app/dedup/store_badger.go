@@ -0,0 +1,164 @@
+package dedup
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v2"
+)
+
+// badgerGCDiscardRatio is the ratio of discardable data in the value log file
+// to be rewritten by the garbage collection.
+const badgerGCDiscardRatio = 0.5
+
+// BadgerStore implements Store on top of Badger database.
+type BadgerStore struct {
+	db *badger.DB
+
+	// drop is held exclusively by DropPrefix and shared by read-write
+	// transactions, because Badger fails writes while dropping keys rather
+	// than waits for the drop to finish.
+	drop sync.RWMutex
+}
+
+// NewBadgerStore returns an instance of Store, which uses given database.
+func NewBadgerStore(db *badger.DB) *BadgerStore {
+	return &BadgerStore{db: db}
+}
+
+func (s *BadgerStore) View(fn func(Txn) error) error {
+	return s.db.View(func(txn *badger.Txn) error {
+		return fn(badgerTxn{txn})
+	})
+}
+
+func (s *BadgerStore) Update(fn func(Txn) error) error {
+	s.drop.RLock()
+	defer s.drop.RUnlock()
+
+	err := s.db.Update(func(txn *badger.Txn) error {
+		return fn(badgerTxn{txn})
+	})
+	if errors.Is(err, badger.ErrConflict) {
+		return ErrTxnConflict
+	}
+	return err
+}
+
+// CollectGarbage runs value log garbage collection until there is nothing to
+// rewrite and returns the number of rewritten value log files.
+func (s *BadgerStore) CollectGarbage() (int, error) {
+	var n int
+	for {
+		err := s.db.RunValueLogGC(badgerGCDiscardRatio)
+		if errors.Is(err, badger.ErrNoRewrite) || errors.Is(err, badger.ErrRejected) {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+// DropPrefix deletes all the keys with any of the prefixes. It waits for the
+// running read-write transactions to finish and new ones wait until it returns.
+func (s *BadgerStore) DropPrefix(prefixes ...[]byte) error {
+	s.drop.Lock()
+	defer s.drop.Unlock()
+
+	for _, prefix := range prefixes {
+		if err := s.db.DropPrefix(prefix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type badgerTxn struct {
+	txn *badger.Txn
+}
+
+func (t badgerTxn) Get(key []byte) ([]byte, error) {
+	item, err := t.txn.Get(key)
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return item.ValueCopy(nil)
+}
+
+func (t badgerTxn) Scan(start, end []byte, fn func(Item) error) error {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = commonPrefix(start, end)
+	iter := t.txn.NewIterator(opts)
+	defer iter.Close()
+
+	for iter.Seek(start); iter.Valid(); iter.Next() {
+		item := iter.Item()
+		if end != nil && bytes.Compare(item.Key(), end) >= 0 {
+			break
+		}
+		if err := fn(badgerItem{item}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t badgerTxn) Put(key, value []byte, ttl time.Duration) error {
+	entry := badger.NewEntry(key, value)
+	if ttl > 0 {
+		entry = entry.WithTTL(ttl)
+	}
+	return badgerError(t.txn.SetEntry(entry))
+}
+
+func (t badgerTxn) Delete(key []byte) error {
+	return badgerError(t.txn.Delete(key))
+}
+
+type badgerItem struct {
+	item *badger.Item
+}
+
+func (i badgerItem) Key() []byte {
+	return i.item.Key()
+}
+
+func (i badgerItem) Value() ([]byte, error) {
+	return i.item.ValueCopy(nil)
+}
+
+func (i badgerItem) ExpiresAt() time.Time {
+	if ts := i.item.ExpiresAt(); ts > 0 {
+		return time.Unix(int64(ts), 0)
+	}
+	return time.Time{}
+}
+
+// commonPrefix returns the longest common prefix of start and end, which all
+// the keys in the range [start, end) share.
+func commonPrefix(start, end []byte) []byte {
+	if end == nil {
+		return nil
+	}
+	n := 0
+	for n < len(start) && n < len(end) && start[n] == end[n] {
+		n++
+	}
+	return start[:n]
+}
+
+// badgerError translates Badger write errors into Store errors.
+func badgerError(err error) error {
+	if errors.Is(err, badger.ErrTxnTooBig) {
+		return ErrTxnTooBig
+	}
+	return err
+}
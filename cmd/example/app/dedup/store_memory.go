@@ -0,0 +1,225 @@
+package dedup
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var errReadOnlyTxn = errors.New("store: write in read-only transaction")
+
+// MemoryStore implements Store in memory. Keys are kept in a sorted slice and
+// transactions are serialised, so they never conflict. Expired keys are not
+// visible to transactions. It is intended for tests and ephemeral deployments.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	entries []*memoryEntry
+	now     func() time.Time
+}
+
+type memoryEntry struct {
+	key       []byte
+	value     []byte
+	expiresAt time.Time
+}
+
+// NewMemoryStore returns an empty instance of MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{now: time.Now}
+}
+
+func (s *MemoryStore) View(fn func(Txn) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return fn(&memoryTxn{store: s, now: s.now()})
+}
+
+func (s *MemoryStore) Update(fn func(Txn) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	txn := memoryTxn{store: s, now: s.now(), writable: true}
+	if err := fn(&txn); err != nil {
+		txn.rollback()
+		return err
+	}
+	return nil
+}
+
+// CollectGarbage removes expired entries and returns their number.
+func (s *MemoryStore) CollectGarbage() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	entries := s.entries[:0]
+	for _, e := range s.entries {
+		if e.expiresAt.IsZero() || now.Before(e.expiresAt) {
+			entries = append(entries, e)
+		}
+	}
+	n := len(s.entries) - len(entries)
+	for i := len(entries); i < len(s.entries); i++ {
+		s.entries[i] = nil
+	}
+	s.entries = entries
+	return n, nil
+}
+
+// DropPrefix removes all the entries with any of the prefixes.
+func (s *MemoryStore) DropPrefix(prefixes ...[]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, prefix := range prefixes {
+		i := s.search(prefix)
+		j := len(s.entries)
+		if end := prefixEnd(prefix); end != nil {
+			j = s.search(end)
+		}
+		s.entries = append(s.entries[:i], s.entries[j:]...)
+	}
+	return nil
+}
+
+// search returns the index of the first entry with key not less than the key.
+func (s *MemoryStore) search(key []byte) int {
+	return sort.Search(len(s.entries), func(i int) bool {
+		return bytes.Compare(s.entries[i].key, key) >= 0
+	})
+}
+
+// set inserts or replaces the entry and returns the replaced entry.
+func (s *MemoryStore) set(e *memoryEntry) *memoryEntry {
+	i := s.search(e.key)
+	if i < len(s.entries) && bytes.Equal(s.entries[i].key, e.key) {
+		old := s.entries[i]
+		s.entries[i] = e
+		return old
+	}
+	s.entries = append(s.entries, nil)
+	copy(s.entries[i+1:], s.entries[i:])
+	s.entries[i] = e
+	return nil
+}
+
+// remove removes the entry of the key and returns it.
+func (s *MemoryStore) remove(key []byte) *memoryEntry {
+	i := s.search(key)
+	if i == len(s.entries) || !bytes.Equal(s.entries[i].key, key) {
+		return nil
+	}
+	old := s.entries[i]
+	s.entries = append(s.entries[:i], s.entries[i+1:]...)
+	return old
+}
+
+// memoryTxn is a MemoryStore transaction. Writes of read-write transaction are
+// applied to the store immediately, because it holds the store lock, and are
+// reverted from the undo log if the transaction is discarded.
+type memoryTxn struct {
+	store    *MemoryStore
+	now      time.Time
+	writable bool
+	undo     []memoryUndo
+}
+
+type memoryUndo struct {
+	key []byte
+	old *memoryEntry
+}
+
+func (t *memoryTxn) Get(key []byte) ([]byte, error) {
+	s := t.store
+	i := s.search(key)
+	if i == len(s.entries) || !bytes.Equal(s.entries[i].key, key) || t.expired(s.entries[i]) {
+		return nil, ErrKeyNotFound
+	}
+	return append([]byte(nil), s.entries[i].value...), nil
+}
+
+func (t *memoryTxn) Scan(start, end []byte, fn func(Item) error) error {
+	s := t.store
+
+	// entries are looked up by key on every step, so that fn can modify the
+	// store during the scan.
+	for i := s.search(start); i < len(s.entries); {
+		e := s.entries[i]
+		if end != nil && bytes.Compare(e.key, end) >= 0 {
+			break
+		}
+		if !t.expired(e) {
+			if err := fn(memoryItem{e}); err != nil {
+				return err
+			}
+		}
+		i = s.search(e.key)
+		if i < len(s.entries) && bytes.Equal(s.entries[i].key, e.key) {
+			i++
+		}
+	}
+	return nil
+}
+
+func (t *memoryTxn) Put(key, value []byte, ttl time.Duration) error {
+	if !t.writable {
+		return errReadOnlyTxn
+	}
+	e := memoryEntry{
+		key:   append([]byte(nil), key...),
+		value: append([]byte(nil), value...),
+	}
+	if ttl > 0 {
+		e.expiresAt = t.now.Add(ttl)
+	}
+	t.log(e.key, t.store.set(&e))
+	return nil
+}
+
+func (t *memoryTxn) Delete(key []byte) error {
+	if !t.writable {
+		return errReadOnlyTxn
+	}
+	key = append([]byte(nil), key...)
+	t.log(key, t.store.remove(key))
+	return nil
+}
+
+func (t *memoryTxn) expired(e *memoryEntry) bool {
+	return !e.expiresAt.IsZero() && !t.now.Before(e.expiresAt)
+}
+
+func (t *memoryTxn) log(key []byte, old *memoryEntry) {
+	t.undo = append(t.undo, memoryUndo{key: key, old: old})
+}
+
+// rollback reverts writes of the transaction in the reverse order.
+func (t *memoryTxn) rollback() {
+	for i := len(t.undo) - 1; i >= 0; i-- {
+		u := t.undo[i]
+		if u.old != nil {
+			t.store.set(u.old)
+		} else {
+			t.store.remove(u.key)
+		}
+	}
+	t.undo = nil
+}
+
+type memoryItem struct {
+	entry *memoryEntry
+}
+
+func (i memoryItem) Key() []byte {
+	return i.entry.key
+}
+
+func (i memoryItem) Value() ([]byte, error) {
+	return append([]byte(nil), i.entry.value...), nil
+}
+
+func (i memoryItem) ExpiresAt() time.Time {
+	return i.entry.expiresAt
+}
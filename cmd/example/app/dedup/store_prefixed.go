@@ -0,0 +1,116 @@
+package dedup
+
+import (
+	"time"
+)
+
+// prefixedStore implements Store on top of another Store, which keys are
+// prefixed, so that several filters share the same database.
+type prefixedStore struct {
+	store  Store
+	prefix []byte
+}
+
+func newPrefixedStore(store Store, prefix []byte) *prefixedStore {
+	return &prefixedStore{store: store, prefix: prefix}
+}
+
+func (s *prefixedStore) View(fn func(Txn) error) error {
+	return s.store.View(func(txn Txn) error {
+		return fn(s.txn(txn))
+	})
+}
+
+func (s *prefixedStore) Update(fn func(Txn) error) error {
+	return s.store.Update(func(txn Txn) error {
+		return fn(s.txn(txn))
+	})
+}
+
+// DropPrefix deletes all the keys with any of the prefixes. Keys are deleted
+// one by one, unless the underlying store supports dropping by prefix.
+func (s *prefixedStore) DropPrefix(prefixes ...[]byte) error {
+	keyed := make([][]byte, len(prefixes))
+	for i, prefix := range prefixes {
+		keyed[i] = prefixKey(s.prefix, prefix)
+	}
+	if dropper, ok := s.store.(PrefixDropper); ok {
+		return dropper.DropPrefix(keyed...)
+	}
+	for _, prefix := range keyed {
+		if err := deletePrefix(s.store, prefix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// txn returns the transaction of the underlying store with prefixed keys.
+func (s *prefixedStore) txn(txn Txn) Txn {
+	return prefixedTxn{txn: txn, prefix: s.prefix}
+}
+
+type prefixedTxn struct {
+	txn    Txn
+	prefix []byte
+}
+
+func (t prefixedTxn) Get(key []byte) ([]byte, error) {
+	return t.txn.Get(t.key(key))
+}
+
+func (t prefixedTxn) Scan(start, end []byte, fn func(Item) error) error {
+	if end == nil {
+		end = prefixEnd(t.prefix)
+	} else {
+		end = t.key(end)
+	}
+	return t.txn.Scan(t.key(start), end, func(item Item) error {
+		return fn(prefixedItem{Item: item, n: len(t.prefix)})
+	})
+}
+
+func (t prefixedTxn) Put(key, value []byte, ttl time.Duration) error {
+	return t.txn.Put(t.key(key), value, ttl)
+}
+
+func (t prefixedTxn) Delete(key []byte) error {
+	return t.txn.Delete(t.key(key))
+}
+
+func (t prefixedTxn) key(key []byte) []byte {
+	return prefixKey(t.prefix, key)
+}
+
+type prefixedItem struct {
+	Item
+	n int
+}
+
+func (i prefixedItem) Key() []byte {
+	return i.Item.Key()[i.n:]
+}
+
+// prefixKey returns a copy of the key with the prefix.
+func prefixKey(prefix, key []byte) []byte {
+	return append(append(make([]byte, 0, len(prefix)+len(key)), prefix...), key...)
+}
+
+// deletePrefix deletes all the keys with the prefix in as many transactions as
+// needed. Keys are collected in a single read-only transaction, so keys stored
+// after the scan are kept.
+func deletePrefix(store Store, prefix []byte) error {
+	var keys [][]byte
+	err := store.View(func(txn Txn) error {
+		return txn.Scan(prefix, prefixEnd(prefix), func(item Item) error {
+			keys = append(keys, append([]byte(nil), item.Key()...))
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	return inBatches(store.Update, len(keys), func(txn Txn, i int) error {
+		return txn.Delete(keys[i])
+	}, nil)
+}
@@ -0,0 +1,105 @@
+package dedup
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// limitStore is a Store, which read-write transactions fail writes with
+// ErrTxnTooBig once they hold the given number of writes.
+type limitStore struct {
+	Store
+	limit int
+}
+
+func (s *limitStore) Update(fn func(Txn) error) error {
+	return s.Store.Update(func(txn Txn) error {
+		return fn(&limitTxn{Txn: txn, limit: s.limit})
+	})
+}
+
+type limitTxn struct {
+	Txn
+	limit  int
+	writes int
+}
+
+func (t *limitTxn) write() error {
+	if t.writes == t.limit {
+		return ErrTxnTooBig
+	}
+	t.writes++
+	return nil
+}
+
+func (t *limitTxn) Put(key, value []byte, ttl time.Duration) error {
+	if err := t.write(); err != nil {
+		return err
+	}
+	return t.Txn.Put(key, value, ttl)
+}
+
+func (t *limitTxn) Delete(key []byte) error {
+	if err := t.write(); err != nil {
+		return err
+	}
+	return t.Txn.Delete(key)
+}
+
+func TestInBatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		limit   int
+		writes  int
+		want    [][2]int
+		wantErr error
+	}{
+		{name: "single transaction", limit: 100, writes: 2, want: [][2]int{{0, 10}}},
+		{name: "whole items", limit: 5, writes: 2, want: [][2]int{{0, 2}, {2, 4}, {4, 6}, {6, 8}, {8, 10}}},
+		{name: "item too big", limit: 1, writes: 2, want: nil, wantErr: ErrTxnTooBig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &limitStore{Store: NewMemoryStore(), limit: tt.limit}
+			var committed [][2]int
+			err := inBatches(store.Update, 10, func(txn Txn, i int) error {
+				for w := 0; w < tt.writes; w++ {
+					if err := txn.Put([]byte(fmt.Sprintf("%d-%d", i, w)), nil, 0); err != nil {
+						return err
+					}
+				}
+				return nil
+			}, func(start, end int) {
+				committed = append(committed, [2]int{start, end})
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(committed, tt.want) {
+				t.Fatalf("got batches %v, want %v", committed, tt.want)
+			}
+
+			// items are either committed with all their writes or not at all.
+			var keys int
+			err = store.View(func(txn Txn) error {
+				return txn.Scan(nil, nil, func(Item) error {
+					keys++
+					return nil
+				})
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var items int
+			for _, b := range committed {
+				items += b[1] - b[0]
+			}
+			if keys != items*tt.writes {
+				t.Fatalf("got %d keys, want %d", keys, items*tt.writes)
+			}
+		})
+	}
+}
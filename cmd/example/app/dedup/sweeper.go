@@ -0,0 +1,124 @@
+package dedup
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SweepStats is the outcome of a single sweep.
+type SweepStats struct {
+	// Deleted is the number of deleted expired locations.
+	Deleted int
+
+	// Dropped is the number of dropped expired time buckets.
+	Dropped int
+
+	// Ended is the number of ended dwells of entities, which are not seen any
+	// more.
+	Ended int
+
+	// Collected is the number of storage units reclaimed by the store garbage
+	// collection, if the store supports it.
+	Collected int
+
+	// Duration is the time the sweep took.
+	Duration time.Duration
+}
+
+// Sweep drops time buckets and deletes locations of every tier, which are too
+// old to match any event within the allowed lateness behind the watermark,
+// ends dwells of entities, which are not seen any more, and runs the store
+// garbage collection. Otherwise expired locations are only deleted, when they
+// are scanned by events nearby.
+func (f *SpatioTemporalFilter) Sweep() (stats SweepStats, err error) {
+	start := time.Now()
+	defer func() {
+		stats.Duration = time.Since(start)
+		atomic.AddUint64(&f.sweeps, 1)
+		atomic.AddUint64(&f.sweptKeys, uint64(stats.Deleted))
+		atomic.AddUint64(&f.dropped, uint64(stats.Dropped))
+		atomic.AddUint64(&f.collected, uint64(stats.Collected))
+	}()
+
+	for _, t := range append([]*SpatioTemporalFilter{f}, f.tiers...) {
+		deleted, dropped, err := t.sweep()
+		stats.Deleted += deleted
+		stats.Dropped += dropped
+		if err != nil {
+			return stats, err
+		}
+	}
+	if stats.Ended, err = f.endDwells(); err != nil {
+		return stats, err
+	}
+
+	if gc, ok := f.store.(GarbageCollector); ok {
+		stats.Collected, err = gc.CollectGarbage()
+	}
+	return stats, err
+}
+
+// sweep drops expired time buckets and deletes expired locations of the tier.
+func (f *SpatioTemporalFilter) sweep() (deleted, dropped int, err error) {
+	cutoff := f.expiryCutoff()
+	if dropped, err = f.dropBuckets(cutoff); err != nil {
+		return 0, dropped, err
+	}
+	deleted, err = f.forget(func(r record) bool {
+		return r.end.Before(cutoff)
+	}, func(Txn) ([][2][]byte, error) {
+		return prefixRanges(encodeTypePrefix()), nil
+	})
+	return deleted, dropped, err
+}
+
+// RunSweeper runs Sweep in the background every interval and calls fn with
+// the outcome of each sweep. It returns a function, which stops the sweeper
+// and waits for the running sweep to finish.
+func (f *SpatioTemporalFilter) RunSweeper(interval time.Duration, fn func(SweepStats, error)) (stop func()) {
+	return every(interval, func() {
+		stats, err := f.Sweep()
+		if fn != nil {
+			fn(stats, err)
+		}
+	})
+}
+
+// every runs fn in the background every interval. It returns a function, which
+// stops the loop and waits for the running fn to finish.
+func every(interval time.Duration, fn func()) (stop func()) {
+	quit := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+				fn()
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(quit)
+			<-done
+		})
+	}
+}
+
+// Swept returns the number of sweeps run, expired locations deleted, time
+// buckets dropped and storage units reclaimed by the store garbage collection.
+func (f *SpatioTemporalFilter) Swept() (sweeps, deleted, dropped, collected uint64) {
+	return atomic.LoadUint64(&f.sweeps), atomic.LoadUint64(&f.sweptKeys),
+		atomic.LoadUint64(&f.dropped), atomic.LoadUint64(&f.collected)
+}
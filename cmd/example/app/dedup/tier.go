@@ -0,0 +1,165 @@
+package dedup
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang/geo/s2"
+)
+
+const (
+	// TierAll treats event as unique, if it is unique in every tier.
+	TierAll TierMode = iota
+
+	// TierAny treats event as unique, if it is unique in any tier.
+	TierAny
+)
+
+// TierMode defines how results of the tiers are combined.
+type TierMode int
+
+// ParseTierMode returns TierMode from its string representation.
+func ParseTierMode(s string) (TierMode, error) {
+	switch s {
+	case "all":
+		return TierAll, nil
+	case "any":
+		return TierAny, nil
+	}
+	return 0, fmt.Errorf("filter: unknown tier mode %q", s)
+}
+
+func (m TierMode) String() string {
+	switch m {
+	case TierAll:
+		return "all"
+	case TierAny:
+		return "any"
+	}
+	return fmt.Sprintf("TierMode(%d)", int(m))
+}
+
+// Tier is a pair of distance and time tolerance, which events are deduplicated
+// with.
+type Tier struct {
+	// Distance is distance tolerance in meters.
+	Distance float64
+
+	// Interval is time tolerance.
+	Interval time.Duration
+
+	// Level is cell level of the tier. Zero level is derived from the distance
+	// tolerance. Level must not be higher than that.
+	Level int
+}
+
+// Tiers returns the filter tier followed by the additional tiers.
+func (f *SpatioTemporalFilter) Tiers() []Tier {
+	tiers := make([]Tier, 0, len(f.tiers)+1)
+	for _, t := range append([]*SpatioTemporalFilter{f}, f.tiers...) {
+		tiers = append(tiers, Tier{
+			Distance: t.Distance(),
+			Interval: t.Interval(),
+			Level:    t.Level(),
+		})
+	}
+	return tiers
+}
+
+// TierMode returns how results of the tiers are combined.
+func (f *SpatioTemporalFilter) TierMode() TierMode {
+	return f.tierMode
+}
+
+// newTiers creates filters of the additional tiers. Each tier keeps its index
+// under its own key prefix in the same store and inherits the window, the
+// accuracy mode with bounds scaled to its distance tolerance, time buckets,
+// allowed lateness, TTL grace margin, TTL of entities capped at the TTL of the
+// tier and conflict retry of the filter. Lateness policy is applied by the
+// filter only. Zones are not inherited, because they would override the
+// tolerance of the tier.
+func (f *SpatioTemporalFilter) newTiers(tiers []Tier) error {
+	if len(tiers) > 0xff {
+		return errors.New("filter: too many tiers")
+	}
+	for i, t := range tiers {
+		scale := t.Distance / f.Distance()
+		entityTTL := make(map[string]time.Duration, len(f.entityTTL))
+		for entity, ttl := range f.entityTTL {
+			if max := t.Interval + f.lateness + f.grace; ttl > max {
+				ttl = max
+			}
+			entityTTL[entity] = ttl
+		}
+		tier, err := NewSpatioTemporalFilter(newPrefixedStore(f.store, []byte{TierKey, byte(i + 1)}), t.Distance, t.Interval,
+			WithWindow(f.window),
+			WithAccuracy(f.accuracyMode, f.minDistance*scale, f.maxDistance*scale),
+			WithTimeBuckets(f.bucket),
+			WithLateness(f.lateness, LatenessAccept),
+			WithTTL(0, f.grace),
+			WithEntityTTL(entityTTL),
+			WithConflictRetry(f.retryAttempts, f.retryBackoff),
+		)
+		if err != nil {
+			return fmt.Errorf("tier %d: %w", i+1, err)
+		}
+		tf := tier.(*SpatioTemporalFilter)
+		if t.Level > 0 {
+			if t.Level > tf.level {
+				return fmt.Errorf("filter: tier %d: level must not be higher than %d", i+1, tf.level)
+			}
+			tf.level = t.Level
+		}
+		tf.parent = f
+		f.tiers = append(f.tiers, tf)
+	}
+	return nil
+}
+
+// filterTiers filters event in the additional tiers in the same transaction
+// and combines their results with the result of the filter tier.
+func (f *SpatioTemporalFilter) filterTiers(txn Txn, ev Event, res Result) (Result, error) {
+	return f.combineTiers(res, func(t *SpatioTemporalFilter, res Result) (Result, error) {
+		return t.filter(t.store.(*prefixedStore).txn(txn), ev, res)
+	})
+}
+
+// findTiers is the read-only counterpart of filterTiers.
+func (f *SpatioTemporalFilter) findTiers(txn Txn, ev Event, res Result) (Result, error) {
+	return f.combineTiers(res, func(t *SpatioTemporalFilter, res Result) (Result, error) {
+		return t.find(t.store.(*prefixedStore).txn(txn), ev, res)
+	})
+}
+
+func (f *SpatioTemporalFilter) combineTiers(res Result, fn func(*SpatioTemporalFilter, Result) (Result, error)) (Result, error) {
+	if len(f.tiers) == 0 {
+		return res, nil
+	}
+	res.Tiers = append(make([]Result, 0, len(f.tiers)+1), res)
+	unique := res.Unique
+	for _, t := range f.tiers {
+		tr, err := fn(t, Result{Late: res.Late})
+		if err != nil {
+			return res, err
+		}
+		res.Tiers = append(res.Tiers, tr)
+		if f.tierMode == TierAny {
+			unique = unique || tr.Unique
+		} else {
+			unique = unique && tr.Unique
+		}
+	}
+	res.Unique = unique
+	return res, nil
+}
+
+// tierCells returns cells of every tier, which are searched for the earlier
+// locations around ll.
+func (f *SpatioTemporalFilter) tierCells(ll s2.LatLng) s2.CellUnion {
+	cells := f.Cells(ll)
+	for _, t := range f.tiers {
+		cells = append(cells, t.Cells(ll)...)
+	}
+	return cells
+}
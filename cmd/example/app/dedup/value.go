@@ -0,0 +1,71 @@
+package dedup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	valueVersion1 byte = 0x01
+
+	valueVersionLen = 1
+)
+
+// value is the payload of the database index entry.
+type value struct {
+	ID         string                 `json:"id,omitempty"`
+	Accuracy   float64                `json:"accuracy,omitempty"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+
+	// aggregates of the merged duplicates, missing from values written before
+	// duplicates were aggregated.
+	Count     int        `json:"count,omitempty"`
+	FirstSeen time.Time  `json:"firstSeen"`
+	LastSeen  time.Time  `json:"lastSeen"`
+	Centroid  [2]float64 `json:"centroid"`
+}
+
+// encodeValue encodes payload into a value of the database index entry.
+func encodeValue(v value) ([]byte, error) {
+	return encodeJSONValue(v)
+}
+
+// decodeValue decodes given slice of bytes (database index value) into
+// payload. Empty value is decoded into empty payload.
+func decodeValue(p []byte) (value, error) {
+	var v value
+	if len(p) == 0 {
+		return v, nil
+	}
+	err := decodeJSONValue(p, &v)
+	return v, err
+}
+
+// encodeJSONValue encodes payload into a value of the database entry.
+// Value format is:
+// - 1 byte, value format version;
+// - N bytes, JSON encoded payload.
+func encodeJSONValue(v interface{}) ([]byte, error) {
+	p, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("filter: %w", err)
+	}
+	return append([]byte{valueVersion1}, p...), nil
+}
+
+// decodeJSONValue decodes given slice of bytes (database entry value) into
+// payload, which v points to.
+func decodeJSONValue(p []byte, v interface{}) error {
+	if len(p) == 0 {
+		return errors.New("filter: empty value")
+	}
+	if p[0] != valueVersion1 {
+		return fmt.Errorf("filter: unknown value version %d", p[0])
+	}
+	if err := json.Unmarshal(p[valueVersionLen:], v); err != nil {
+		return fmt.Errorf("filter: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package dedup
+
+import "fmt"
+
+const (
+	// WindowTumbling keeps the first event of the location and its window
+	// expires the time tolerance after it, regardless of duplicates.
+	WindowTumbling WindowMode = iota
+
+	// WindowSliding moves the location to the time of each later duplicate,
+	// which extends its window and TTL, so that a stationary entity produces
+	// one unique event until it leaves.
+	WindowSliding
+)
+
+// WindowMode defines how duplicates affect the time window of the location.
+type WindowMode int
+
+// ParseWindowMode returns WindowMode from its string representation.
+func ParseWindowMode(s string) (WindowMode, error) {
+	switch s {
+	case "tumbling":
+		return WindowTumbling, nil
+	case "sliding":
+		return WindowSliding, nil
+	}
+	return 0, fmt.Errorf("filter: unknown window mode %q", s)
+}
+
+func (m WindowMode) String() string {
+	switch m {
+	case WindowTumbling:
+		return "tumbling"
+	case WindowSliding:
+		return "sliding"
+	}
+	return fmt.Sprintf("WindowMode(%d)", int(m))
+}
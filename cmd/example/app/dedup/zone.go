@@ -0,0 +1,143 @@
+package dedup
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang/geo/s1"
+	"github.com/golang/geo/s2"
+)
+
+// Zone is an area with its own distance and time tolerance, which overrides
+// the filter tolerance for events within the area.
+type Zone struct {
+	// Name is an optional name of the zone.
+	Name string
+
+	// Loop is the boundary of the zone.
+	Loop *s2.Loop
+
+	// Distance is distance tolerance in meters.
+	Distance float64
+
+	// Interval is time tolerance.
+	Interval time.Duration
+}
+
+// tolerance is distance and time tolerance in effect for events at a location.
+type tolerance struct {
+	zone     *Zone
+	distance float64
+	min, max float64
+	interval time.Duration
+}
+
+// Zones returns zones of the filter.
+func (f *SpatioTemporalFilter) Zones() []Zone {
+	return append([]Zone(nil), f.zones...)
+}
+
+// ZoneAt returns the zone, which contains ll, or nil if there is none. Zones
+// listed first take precedence, where zones overlap.
+func (f *SpatioTemporalFilter) ZoneAt(ll s2.LatLng) *Zone {
+	if len(f.zones) == 0 {
+		return nil
+	}
+	q := s2.NewContainsPointQuery(f.zoneIndex, s2.VertexModelSemiOpen)
+	i := -1
+	for _, shape := range q.ContainingShapes(s2.PointFromLatLng(ll)) {
+		if j := f.zoneShapes[shape]; i < 0 || j < i {
+			i = j
+		}
+	}
+	if i < 0 {
+		return nil
+	}
+	return &f.zones[i]
+}
+
+// ToleranceAt returns distance tolerance in meters between two events with
+// given accuracies in the zone, which contains ll.
+func (f *SpatioTemporalFilter) ToleranceAt(ll s2.LatLng, a, b float64) float64 {
+	return f.combine(f.toleranceAt(ll), a, b)
+}
+
+// indexZones validates zones and indexes their boundaries. Accuracy bounds of
+// the zones are the filter bounds scaled to the zone distance tolerance.
+func (f *SpatioTemporalFilter) indexZones() error {
+	f.maxInterval = f.interval
+	if len(f.zones) == 0 {
+		return nil
+	}
+	f.zoneIndex = s2.NewShapeIndex()
+	f.zoneShapes = make(map[s2.Shape]int, len(f.zones))
+	for i, z := range f.zones {
+		switch {
+		case z.Loop == nil:
+			return fmt.Errorf("filter: zone %d has no boundary", i)
+		case z.Distance <= 0:
+			return fmt.Errorf("filter: distance tolerance of zone %d must be greater than zero", i)
+		case z.Interval <= 0:
+			return fmt.Errorf("filter: time tolerance of zone %d must be greater than zero", i)
+		}
+		if err := z.Loop.Validate(); err != nil {
+			return fmt.Errorf("filter: zone %d: %w", i, err)
+		}
+		if z.Interval > f.maxInterval {
+			f.maxInterval = z.Interval
+		}
+		f.zoneShapes[z.Loop] = i
+		f.zoneIndex.Add(z.Loop)
+	}
+	if len(f.zoneShapes) != len(f.zones) {
+		return errors.New("filter: zones must not share boundaries")
+	}
+
+	// index is built once, so that queries only read it.
+	f.zoneIndex.Begin()
+	return nil
+}
+
+// toleranceAt returns distance and time tolerance for events at ll.
+func (f *SpatioTemporalFilter) toleranceAt(ll s2.LatLng) tolerance {
+	return f.toleranceOf(f.ZoneAt(ll))
+}
+
+// toleranceOf returns distance and time tolerance of the zone, or the filter
+// tolerance if zone is nil.
+func (f *SpatioTemporalFilter) toleranceOf(z *Zone) tolerance {
+	if z == nil {
+		return tolerance{
+			distance: f.Distance(),
+			min:      f.minDistance,
+			max:      f.maxDistance,
+			interval: f.interval,
+		}
+	}
+	scale := z.Distance / f.Distance()
+	return tolerance{
+		zone:     z,
+		distance: z.Distance,
+		min:      f.minDistance * scale,
+		max:      f.maxDistance * scale,
+		interval: z.Interval,
+	}
+}
+
+// combine returns distance tolerance in meters between two events with given
+// accuracies.
+func (f *SpatioTemporalFilter) combine(t tolerance, a, b float64) float64 {
+	if f.accuracyMode == AccuracyIgnore {
+		return t.distance
+	}
+	return f.accuracyMode.combine(a, b, t.min, t.max)
+}
+
+// search returns the largest distance tolerance between any events.
+func (f *SpatioTemporalFilter) search(t tolerance) s1.ChordAngle {
+	if f.accuracyMode == AccuracyIgnore {
+		return chordAngle(t.distance)
+	}
+	return chordAngle(t.max)
+}
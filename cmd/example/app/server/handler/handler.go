@@ -1,11 +1,15 @@
 package handler
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 
+	"github.com/go-chi/chi"
 	"github.com/golang/geo/s2"
 
 	"github.com/roman-kulish/spatio-temporal-deduplication-example/cmd/example/app/dedup"
@@ -30,9 +34,50 @@ func MethodNotAllowed(w http.ResponseWriter, _ *http.Request) {
 
 // Info returns filter configuration parameters.
 func Info(filter *dedup.SpatioTemporalFilter, w http.ResponseWriter, _ *http.Request) error {
+	accuracyMode, minDistance, maxDistance := filter.Accuracy()
+	lateness, latenessPolicy := filter.Lateness()
+	skew, skewPolicy := filter.MaxFutureSkew()
+	clamped, rejected := filter.SkewedEvents()
+	maxSpeed, speedPolicy := filter.MaxSpeed()
+	sweeps, swept, dropped, collected := filter.Swept()
+	var tiers []model.Tier
+	for _, t := range filter.Tiers() {
+		tiers = append(tiers, model.Tier{
+			Distance: fmt.Sprintf("%0.2f", t.Distance),
+			TTL:      t.Interval.String(),
+			Level:    t.Level,
+		})
+	}
+	entityTTL := make(map[string]string)
+	for entity, ttl := range filter.EntityTTL() {
+		entityTTL[entity] = ttl.String()
+	}
 	response.SendResponse(w, http.StatusOK, &response.Response{Data: model.Info{
-		Distance: fmt.Sprintf("%0.2f", filter.Distance()),
-		TTL:      filter.Interval().String(),
+		Distance:       fmt.Sprintf("%0.2f", filter.Distance()),
+		TTL:            filter.Interval().String(),
+		KeyTTL:         filter.TTL().String(),
+		EntityTTL:      entityTTL,
+		Window:         filter.Window().String(),
+		TimeBucket:     filter.TimeBucket().String(),
+		Tiers:          tiers,
+		TierMode:       filter.TierMode().String(),
+		AccuracyMode:   accuracyMode.String(),
+		MinDistance:    fmt.Sprintf("%0.2f", minDistance),
+		MaxDistance:    fmt.Sprintf("%0.2f", maxDistance),
+		Lateness:       lateness.String(),
+		LatenessPolicy: latenessPolicy.String(),
+		MaxFutureSkew:  skew.String(),
+		SkewPolicy:     skewPolicy.String(),
+		SkewClamped:    clamped,
+		SkewRejected:   rejected,
+		MaxSpeed:       fmt.Sprintf("%0.2f", maxSpeed),
+		SpeedPolicy:    speedPolicy.String(),
+		MinDwell:       filter.MinDwell().String(),
+		Sweeps:         sweeps,
+		SweptKeys:      swept,
+		Dropped:        dropped,
+		Collected:      collected,
+		Watermark:      filter.Watermark(),
 	}})
 	return nil
 }
@@ -49,19 +94,84 @@ func AddLocation(filter *dedup.SpatioTemporalFilter, w http.ResponseWriter, r *h
 		return err
 	}
 
-	isUnique, err := filter.Filter(ev)
+	res, err := filter.Filter(ev)
+	if err != nil {
+		return filterError(err)
+	}
+
+	fc := pushResult(s2geojson.NewFeatureCollection(), filter, ev, res).
+		Push(makeGrid(filter.Cells(s2.LatLngFromDegrees(ev.Lat, ev.Lng))))
+
+	response.SendResponse(w, http.StatusOK, &response.Response{Data: fc})
+	return nil
+}
+
+// CheckLocation checks whether event location is a duplicate and returns
+// result without storing the event.
+func CheckLocation(filter *dedup.SpatioTemporalFilter, w http.ResponseWriter, r *http.Request) error {
+	var ev dedup.Event
+
+	p, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(p, &ev); err != nil {
+		return err
+	}
+
+	res, err := filter.Contains(ev)
+	if err != nil {
+		return filterError(err)
+	}
+
+	fc := pushResult(s2geojson.NewFeatureCollection(), filter, ev, res).
+		Push(makeGrid(filter.Cells(s2.LatLngFromDegrees(ev.Lat, ev.Lng))))
+
+	response.SendResponse(w, http.StatusOK, &response.Response{Data: fc})
+	return nil
+}
+
+// AddLocations runs a batch of event locations through the filter and returns
+// results. Request body is either a JSON array or newline delimited JSON
+// events.
+func AddLocations(filter *dedup.SpatioTemporalFilter, w http.ResponseWriter, r *http.Request) error {
+	p, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		return err
 	}
+	evs, err := decodeEvents(p)
+	if err != nil {
+		return &response.Error{
+			StatusCode: http.StatusBadRequest,
+			Status:     response.InvalidRequest,
+			Err:        err,
+		}
+	}
 
-	ll := s2.LatLngFromDegrees(ev.Lat, ev.Lng)
-	fc := s2geojson.NewFeatureCollection().
-		Push(makePoint(ev.Lat, ev.Lng, map[string]interface{}{
-			"type":   "location",
-			"unique": isUnique,
-			"radius": filter.Distance(),
-		})).
-		Push(makeGrid(filter.Cells(ll)))
+	results, err := filter.FilterBatch(evs)
+	if err != nil {
+		return filterError(err)
+	}
+
+	fc := s2geojson.NewFeatureCollection()
+	for i, res := range results {
+		if res.Err != nil {
+			status := response.InternalError
+			var e *response.Error
+			if errors.As(filterError(res.Err), &e) {
+				status = e.Status
+			}
+			fc.Push(makePoint(evs[i].Lat, evs[i].Lng, map[string]interface{}{
+				"type":   "location",
+				"id":     evs[i].ID,
+				"entity": evs[i].Entity,
+				"status": status,
+				"error":  res.Err.Error(),
+			}))
+			continue
+		}
+		pushResult(fc, filter, evs[i], res.Result)
+	}
 
 	response.SendResponse(w, http.StatusOK, &response.Response{Data: fc})
 	return nil
@@ -71,8 +181,145 @@ func AddLocation(filter *dedup.SpatioTemporalFilter, w http.ResponseWriter, r *h
 func IndexedLocations(filter *dedup.SpatioTemporalFilter, w http.ResponseWriter, _ *http.Request) error {
 	fc := s2geojson.NewFeatureCollection()
 
-	err := filter.IndexedLocations(func(lat, lng float64) error {
-		fc.Push(makePoint(lat, lng, nil))
+	if err := filter.IndexedLocations(pushLocation(fc)); err != nil {
+		return err
+	}
+
+	response.SendResponse(w, http.StatusOK, &response.Response{Data: fc})
+	return nil
+}
+
+// EntityLocations outputs a list of indexed locations of a single entity from
+// the filter.
+func EntityLocations(filter *dedup.SpatioTemporalFilter, w http.ResponseWriter, r *http.Request) error {
+	fc := s2geojson.NewFeatureCollection()
+
+	err := filter.EntityLocations(chi.URLParam(r, "entity"), pushLocation(fc))
+	if errors.Is(err, dedup.ErrEntityTooLong) {
+		return &response.Error{
+			StatusCode: http.StatusBadRequest,
+			Status:     response.InvalidRequest,
+			Err:        err,
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	response.SendResponse(w, http.StatusOK, &response.Response{Data: fc})
+	return nil
+}
+
+// ForgetEntity deletes all the stored locations of a single entity.
+func ForgetEntity(filter *dedup.SpatioTemporalFilter, w http.ResponseWriter, r *http.Request) error {
+	n, err := filter.ForgetEntity(chi.URLParam(r, "entity"))
+	if errors.Is(err, dedup.ErrEntityTooLong) {
+		return &response.Error{
+			StatusCode: http.StatusBadRequest,
+			Status:     response.InvalidRequest,
+			Err:        err,
+		}
+	}
+	if err != nil {
+		return filterError(err)
+	}
+
+	response.SendResponse(w, http.StatusOK, &response.Response{Data: model.Deleted{Deleted: n}})
+	return nil
+}
+
+// ForgetRegion deletes stored locations of all entities within a bounding box
+// or a polygon.
+func ForgetRegion(filter *dedup.SpatioTemporalFilter, w http.ResponseWriter, r *http.Request) error {
+	var rg model.Region
+	p, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(p, &rg); err != nil {
+		return &response.Error{
+			StatusCode: http.StatusBadRequest,
+			Status:     response.InvalidRequest,
+			Err:        err,
+		}
+	}
+
+	var region s2.Region
+	switch {
+	case rg.Polygon != nil:
+		region = rg.Polygon.Loop
+	case rg.BBox != nil:
+		region = s2.RectFromLatLng(s2.LatLngFromDegrees(rg.BBox.Hi.Lat, rg.BBox.Hi.Lng)).
+			AddPoint(s2.LatLngFromDegrees(rg.BBox.Lo.Lat, rg.BBox.Lo.Lng))
+	default:
+		return &response.Error{
+			StatusCode: http.StatusBadRequest,
+			Status:     response.InvalidRequest,
+			Err:        errors.New("either bbox or polygon is required"),
+		}
+	}
+
+	n, err := filter.ForgetRegion(region)
+	if err != nil {
+		return filterError(err)
+	}
+
+	response.SendResponse(w, http.StatusOK, &response.Response{Data: model.Deleted{Deleted: n}})
+	return nil
+}
+
+// ForgetTimeRange deletes stored locations of all entities within a time
+// range.
+func ForgetTimeRange(filter *dedup.SpatioTemporalFilter, w http.ResponseWriter, r *http.Request) error {
+	var tr model.TimeRange
+	p, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(p, &tr); err != nil {
+		return &response.Error{
+			StatusCode: http.StatusBadRequest,
+			Status:     response.InvalidRequest,
+			Err:        err,
+		}
+	}
+	if !tr.From.Before(tr.To) {
+		return &response.Error{
+			StatusCode: http.StatusBadRequest,
+			Status:     response.InvalidRequest,
+			Err:        errors.New("from must be before to"),
+		}
+	}
+
+	n, err := filter.ForgetTimeRange(tr.From, tr.To)
+	if err != nil {
+		return filterError(err)
+	}
+
+	response.SendResponse(w, http.StatusOK, &response.Response{Data: model.Deleted{Deleted: n}})
+	return nil
+}
+
+// Dwells outputs a list of ongoing and ended dwells from the filter.
+func Dwells(filter *dedup.SpatioTemporalFilter, w http.ResponseWriter, _ *http.Request) error {
+	fc := s2geojson.NewFeatureCollection()
+
+	err := filter.Dwells(func(d dedup.Dwell) error {
+		state := "ongoing"
+		if !d.Ongoing() {
+			state = "ended"
+		}
+		fc.Push(makePoint(d.Center.Lat.Degrees(), d.Center.Lng.Degrees(), map[string]interface{}{
+			"type":     "dwell",
+			"entity":   d.Entity,
+			"state":    state,
+			"start":    d.Start,
+			"end":      d.End,
+			"lastSeen": d.LastSeen,
+			"duration": d.Duration().String(),
+			"count":    d.Count,
+			"radius":   filter.ToleranceAt(d.Center, d.Accuracy, 0),
+		}))
 		return nil
 	})
 	if err != nil {
@@ -83,7 +330,92 @@ func IndexedLocations(filter *dedup.SpatioTemporalFilter, w http.ResponseWriter,
 	return nil
 }
 
-// MapGrid outputs a grid of S2 Cells for the map, using filter level.
+// Zones outputs tolerance zones of the filter.
+func Zones(filter *dedup.SpatioTemporalFilter, w http.ResponseWriter, _ *http.Request) error {
+	fc := s2geojson.NewFeatureCollection()
+	for _, z := range filter.Zones() {
+		ft := s2geojson.NewFeature(s2geojson.NewPolygon(z.Loop))
+		ft.Properties["type"] = "zone"
+		ft.Properties["name"] = z.Name
+		ft.Properties["distance"] = z.Distance
+		ft.Properties["interval"] = z.Interval.String()
+		fc.Push(ft)
+	}
+
+	response.SendResponse(w, http.StatusOK, &response.Response{Data: fc})
+	return nil
+}
+
+// Exclusions outputs exclusion zones of the filter.
+func Exclusions(filter *dedup.SpatioTemporalFilter, w http.ResponseWriter, _ *http.Request) error {
+	fc := s2geojson.NewFeatureCollection()
+	for _, e := range filter.Exclusions() {
+		pushExclusion(fc, e)
+	}
+
+	response.SendResponse(w, http.StatusOK, &response.Response{Data: fc})
+	return nil
+}
+
+// PutExclusion creates or replaces an exclusion zone given by a polygon, S2
+// cell tokens or both.
+func PutExclusion(filter *dedup.SpatioTemporalFilter, w http.ResponseWriter, r *http.Request) error {
+	var me model.Exclusion
+	p, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(p, &me); err != nil {
+		return &response.Error{
+			StatusCode: http.StatusBadRequest,
+			Status:     response.InvalidRequest,
+			Err:        err,
+		}
+	}
+
+	e := dedup.Exclusion{Name: me.Name}
+	if me.Polygon != nil {
+		e.Loop = me.Polygon.Loop
+	}
+	for _, token := range me.Cells {
+		e.Cells = append(e.Cells, s2.CellIDFromToken(token))
+	}
+	err = filter.PutExclusion(e)
+	if errors.Is(err, dedup.ErrInvalidExclusion) {
+		return &response.Error{
+			StatusCode: http.StatusBadRequest,
+			Status:     response.InvalidRequest,
+			Err:        err,
+		}
+	}
+	if err != nil {
+		return filterError(err)
+	}
+
+	response.SendResponse(w, http.StatusOK, &response.Response{Data: pushExclusion(s2geojson.NewFeatureCollection(), e)})
+	return nil
+}
+
+// DeleteExclusion deletes an exclusion zone.
+func DeleteExclusion(filter *dedup.SpatioTemporalFilter, w http.ResponseWriter, r *http.Request) error {
+	err := filter.DeleteExclusion(chi.URLParam(r, "exclusion"))
+	if errors.Is(err, dedup.ErrExclusionNotFound) {
+		return &response.Error{
+			StatusCode: http.StatusNotFound,
+			Status:     response.NotFound,
+			Err:        err,
+		}
+	}
+	if err != nil {
+		return filterError(err)
+	}
+
+	response.SendResponse(w, http.StatusOK, &response.Response{})
+	return nil
+}
+
+// MapGrid outputs a grid of S2 Cells for the map for each tier, using level of
+// the tier, along with outlines of exclusion zones within the bounding box.
 func MapGrid(filter *dedup.SpatioTemporalFilter, w http.ResponseWriter, r *http.Request) error {
 	var b model.BBox
 	p, err := ioutil.ReadAll(r.Body)
@@ -98,16 +430,142 @@ func MapGrid(filter *dedup.SpatioTemporalFilter, w http.ResponseWriter, r *http.
 	lo := s2.LatLngFromDegrees(b.Lo.Lat, b.Lo.Lng)
 
 	vb := s2.RectFromLatLng(hi).AddPoint(lo)
-	rc := s2.RegionCoverer{
-		MinLevel: filter.Level(),
-		MaxLevel: filter.Level(),
+	fc := s2geojson.NewFeatureCollection()
+	for i, t := range filter.Tiers() {
+		rc := s2.RegionCoverer{
+			MinLevel: t.Level,
+			MaxLevel: t.Level,
+		}
+		grid := makeGrid(rc.Covering(vb))
+		grid.Properties["type"] = "grid"
+		grid.Properties["tier"] = i
+		grid.Properties["level"] = t.Level
+		fc.Push(grid)
+	}
+	for _, e := range filter.Exclusions() {
+		if exclusionBound(e).Intersects(vb) {
+			pushExclusion(fc, e)
+		}
 	}
-	cu := rc.Covering(vb)
 
-	response.SendResponse(w, http.StatusOK, &response.Response{Data: makeGrid(cu)})
+	response.SendResponse(w, http.StatusOK, &response.Response{Data: fc})
 	return nil
 }
 
+// filterError wraps errors caused by the event, which was rejected by the
+// filter, or by the concurrent events into response.Error.
+func filterError(err error) error {
+	var (
+		lateErr   *dedup.LateEventError
+		futureErr *dedup.FutureEventError
+		speedErr  *dedup.SpeedError
+	)
+	switch {
+	case errors.As(err, &lateErr):
+		return &response.Error{
+			StatusCode: http.StatusUnprocessableEntity,
+			Status:     response.LateEvent,
+			Err:        err,
+		}
+	case errors.As(err, &futureErr):
+		return &response.Error{
+			StatusCode: http.StatusUnprocessableEntity,
+			Status:     response.FutureEvent,
+			Err:        err,
+		}
+	case errors.As(err, &speedErr):
+		return &response.Error{
+			StatusCode: http.StatusUnprocessableEntity,
+			Status:     response.ImpossibleSpeed,
+			Err:        err,
+		}
+	case errors.Is(err, dedup.ErrConflict):
+		return &response.Error{
+			StatusCode: http.StatusConflict,
+			Status:     response.Conflict,
+			Err:        err,
+		}
+	}
+	return err
+}
+
+// decodeEvents decodes either a JSON array or newline delimited JSON events.
+func decodeEvents(p []byte) ([]dedup.Event, error) {
+	var evs []dedup.Event
+	if p = bytes.TrimSpace(p); bytes.HasPrefix(p, []byte("[")) {
+		err := json.Unmarshal(p, &evs)
+		return evs, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(p))
+	for {
+		var ev dedup.Event
+		err := dec.Decode(&ev)
+		if errors.Is(err, io.EOF) {
+			return evs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		evs = append(evs, ev)
+	}
+}
+
+// pushResult pushes event location with the filter result and a line to the
+// matched location into the collection.
+func pushResult(fc *s2geojson.FeatureCollection, filter *dedup.SpatioTemporalFilter, ev dedup.Event, res dedup.Result) *s2geojson.FeatureCollection {
+	props := map[string]interface{}{
+		"type":     "location",
+		"id":       ev.ID,
+		"entity":   ev.Entity,
+		"unique":   res.Unique,
+		"late":     res.Late,
+		"routed":   res.Routed,
+		"accuracy": ev.Accuracy,
+		"radius":   filter.ToleranceAt(s2.LatLngFromDegrees(ev.Lat, ev.Lng), ev.Accuracy, 0),
+	}
+	if res.Match != nil {
+		props["match"] = locationProps(*res.Match)
+		props["matchDistance"] = res.Distance
+		props["matchTimeDelta"] = res.TimeDelta.String()
+		props["matchTolerance"] = res.Tolerance
+
+		ll := s2.LatLngFromDegrees(ev.Lat, ev.Lng)
+		ln := s2geojson.NewFeature(s2geojson.NewLineString(ll, res.Match.LatLng()))
+		ln.Properties["type"] = "match"
+		ln.Properties["distance"] = res.Distance
+		ln.Properties["timeDelta"] = res.TimeDelta.String()
+		fc.Push(ln)
+	}
+	if res.Flagged {
+		maxSpeed, _ := filter.MaxSpeed()
+		props["flagged"] = true
+		props["speed"] = res.Speed
+		props["reason"] = (&dedup.SpeedError{Speed: res.Speed, MaxSpeed: maxSpeed}).Error()
+	}
+	if res.Dwell != nil {
+		props["dwell"] = res.Dwell.Type.String()
+	}
+	if res.Zone != "" {
+		props["zone"] = res.Zone
+	}
+	if res.Excluded != "" {
+		props["excluded"] = res.Excluded
+	}
+	if len(res.Tiers) > 0 {
+		tiers := make([]map[string]interface{}, 0, len(res.Tiers))
+		for _, tr := range res.Tiers {
+			tp := map[string]interface{}{"unique": tr.Unique}
+			if tr.Match != nil {
+				tp["matchDistance"] = tr.Distance
+				tp["matchTimeDelta"] = tr.TimeDelta.String()
+			}
+			tiers = append(tiers, tp)
+		}
+		props["tiers"] = tiers
+	}
+	return fc.Push(makePoint(ev.Lat, ev.Lng, props))
+}
+
 func makePoint(lat, lng float64, props map[string]interface{}) *s2geojson.Feature {
 	pt := s2geojson.NewPoint(lat, lng)
 	ft := s2geojson.NewFeature(pt)
@@ -119,6 +577,63 @@ func makePoint(lat, lng float64, props map[string]interface{}) *s2geojson.Featur
 	return ft
 }
 
+func pushLocation(fc *s2geojson.FeatureCollection) func(dedup.Location) error {
+	return func(loc dedup.Location) error {
+		ll := loc.LatLng()
+		ft := makePoint(ll.Lat.Degrees(), ll.Lng.Degrees(), locationProps(loc))
+		ft.ID = loc.ID
+		fc.Push(ft)
+		return nil
+	}
+}
+
+func locationProps(loc dedup.Location) map[string]interface{} {
+	return map[string]interface{}{
+		"id":         loc.ID,
+		"entity":     loc.Entity,
+		"cell":       loc.CellID.ToToken(),
+		"time":       loc.Time,
+		"accuracy":   loc.Accuracy,
+		"attributes": loc.Attributes,
+		"count":      loc.Count,
+		"firstSeen":  loc.FirstSeen,
+		"lastSeen":   loc.LastSeen,
+		"centroid":   s2geojson.NewPoint(loc.Centroid.Lat.Degrees(), loc.Centroid.Lng.Degrees()),
+	}
+}
+
+// pushExclusion adds outlines of the exclusion zone polygon and cells to the
+// collection.
+func pushExclusion(fc *s2geojson.FeatureCollection, e dedup.Exclusion) *s2geojson.FeatureCollection {
+	if e.Loop != nil {
+		ft := s2geojson.NewFeature(s2geojson.NewPolygon(e.Loop))
+		ft.Properties["type"] = "exclusion"
+		ft.Properties["name"] = e.Name
+		fc.Push(ft)
+	}
+	if len(e.Cells) > 0 {
+		ft := makeGrid(e.Cells)
+		ft.Properties["type"] = "exclusion"
+		ft.Properties["name"] = e.Name
+		tokens := make([]string, 0, len(e.Cells))
+		for _, id := range e.Cells {
+			tokens = append(tokens, id.ToToken())
+		}
+		ft.Properties["cells"] = tokens
+		fc.Push(ft)
+	}
+	return fc
+}
+
+// exclusionBound returns the bounding rectangle of the exclusion zone.
+func exclusionBound(e dedup.Exclusion) s2.Rect {
+	bound := s2.EmptyRect()
+	if e.Loop != nil {
+		bound = bound.Union(e.Loop.RectBound())
+	}
+	return bound.Union(e.Cells.RectBound())
+}
+
 func makeGrid(cu s2.CellUnion) *s2geojson.Feature {
 	mp := s2geojson.NewMultiPolygon()
 	for _, cell := range cu {
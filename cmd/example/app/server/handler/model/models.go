@@ -1,9 +1,66 @@
 package model
 
-// Info contains distance and time tolerance information.
+import (
+	"time"
+
+	"github.com/roman-kulish/spatio-temporal-deduplication-example/cmd/example/app/server/handler/s2geojson"
+)
+
+// Info contains distance and time tolerance of every tier, late and
+// future-dated events, speed check, dwell detection and sweeper information.
+// TTL of entities only shortens the key TTL and is not used with time buckets.
 type Info struct {
+	Distance       string            `json:"distance"`
+	TTL            string            `json:"ttl"`
+	KeyTTL         string            `json:"keyTTL"`
+	EntityTTL      map[string]string `json:"entityTTL,omitempty"`
+	Window         string            `json:"window"`
+	TimeBucket     string            `json:"timeBucket"`
+	Tiers          []Tier            `json:"tiers"`
+	TierMode       string            `json:"tierMode"`
+	AccuracyMode   string            `json:"accuracyMode"`
+	MinDistance    string            `json:"minDistance"`
+	MaxDistance    string            `json:"maxDistance"`
+	Lateness       string            `json:"lateness"`
+	LatenessPolicy string            `json:"latenessPolicy"`
+	MaxFutureSkew  string            `json:"maxFutureSkew"`
+	SkewPolicy     string            `json:"skewPolicy"`
+	SkewClamped    uint64            `json:"skewClamped"`
+	SkewRejected   uint64            `json:"skewRejected"`
+	MaxSpeed       string            `json:"maxSpeed"`
+	SpeedPolicy    string            `json:"speedPolicy"`
+	MinDwell       string            `json:"minDwell"`
+	Sweeps         uint64            `json:"sweeps"`
+	SweptKeys      uint64            `json:"sweptKeys"`
+	Dropped        uint64            `json:"dropped"`
+	Collected      uint64            `json:"collected"`
+	Watermark      time.Time         `json:"watermark"`
+}
+
+// Tier contains distance and time tolerance and cell level of the tier.
+type Tier struct {
 	Distance string `json:"distance"`
 	TTL      string `json:"ttl"`
+	Level    int    `json:"level"`
+}
+
+// Profile contains parameters of the named filter profile. Durations are in
+// Go duration format, empty ones default to the zero value.
+type Profile struct {
+	Name           string  `json:"name"`
+	Distance       float64 `json:"distance"`
+	TTL            string  `json:"ttl"`
+	Window         string  `json:"window,omitempty"`
+	TimeBucket     string  `json:"timeBucket,omitempty"`
+	KeyTTL         string  `json:"keyTTL,omitempty"`
+	TTLGrace       string  `json:"ttlGrace,omitempty"`
+	Lateness       string  `json:"lateness,omitempty"`
+	LatenessPolicy string  `json:"latenessPolicy,omitempty"`
+
+	EntityTTL    map[string]string `json:"entityTTL,omitempty"`
+	AccuracyMode string            `json:"accuracyMode,omitempty"`
+	MinDistance  float64           `json:"minDistance,omitempty"`
+	MaxDistance  float64           `json:"maxDistance,omitempty"`
 }
 
 // LatLng contains latitude and longitude pair.
@@ -17,3 +74,28 @@ type BBox struct {
 	Hi LatLng `json:"hi"`
 	Lo LatLng `json:"lo"`
 }
+
+// Region is either a bounding box or a GeoJSON polygon.
+type Region struct {
+	BBox    *BBox              `json:"bbox,omitempty"`
+	Polygon *s2geojson.Polygon `json:"polygon,omitempty"`
+}
+
+// Exclusion is an exclusion zone given by a GeoJSON polygon, S2 cell tokens or
+// both.
+type Exclusion struct {
+	Name    string             `json:"name"`
+	Polygon *s2geojson.Polygon `json:"polygon,omitempty"`
+	Cells   []string           `json:"cells,omitempty"`
+}
+
+// TimeRange is a time range [From, To).
+type TimeRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// Deleted contains the number of deleted keys.
+type Deleted struct {
+	Deleted int `json:"deleted"`
+}
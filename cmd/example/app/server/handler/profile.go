@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+
+	"github.com/roman-kulish/spatio-temporal-deduplication-example/cmd/example/app/dedup"
+	"github.com/roman-kulish/spatio-temporal-deduplication-example/cmd/example/app/server/handler/model"
+	"github.com/roman-kulish/spatio-temporal-deduplication-example/cmd/example/app/server/response"
+)
+
+// Profiles outputs the named filter profiles.
+func Profiles(reg *dedup.Registry, w http.ResponseWriter, _ *http.Request) error {
+	profiles := make([]model.Profile, 0)
+	for _, p := range reg.Profiles() {
+		profiles = append(profiles, makeProfile(p))
+	}
+
+	response.SendResponse(w, http.StatusOK, &response.Response{Data: profiles})
+	return nil
+}
+
+// CreateProfile creates the named filter profile.
+func CreateProfile(reg *dedup.Registry, w http.ResponseWriter, r *http.Request) error {
+	var mp model.Profile
+	p, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(p, &mp); err != nil {
+		return err
+	}
+
+	profile, err := parseProfile(mp)
+	if err != nil {
+		return &response.Error{
+			StatusCode: http.StatusBadRequest,
+			Status:     response.InvalidRequest,
+			Err:        err,
+		}
+	}
+	profile, err = reg.Create(profile, false)
+	switch {
+	case errors.Is(err, dedup.ErrProfileExists):
+		return &response.Error{
+			StatusCode: http.StatusConflict,
+			Status:     response.Conflict,
+			Err:        err,
+		}
+	case errors.Is(err, dedup.ErrInvalidProfile), errors.Is(err, dedup.ErrSweeperRequired):
+		return &response.Error{
+			StatusCode: http.StatusBadRequest,
+			Status:     response.InvalidRequest,
+			Err:        err,
+		}
+	case err != nil:
+		return err
+	}
+
+	response.SendResponse(w, http.StatusCreated, &response.Response{Data: makeProfile(profile)})
+	return nil
+}
+
+// DeleteProfile deletes the named filter profile and its index.
+func DeleteProfile(reg *dedup.Registry, w http.ResponseWriter, r *http.Request) error {
+	name := chi.URLParam(r, "name")
+	if name == dedup.DefaultProfile {
+		return &response.Error{
+			StatusCode: http.StatusBadRequest,
+			Status:     response.InvalidRequest,
+			Err:        errors.New("default profile cannot be deleted"),
+		}
+	}
+	err := reg.Delete(name)
+	switch {
+	case errors.Is(err, dedup.ErrProfileNotFound):
+		return &response.Error{
+			StatusCode: http.StatusNotFound,
+			Status:     response.NotFound,
+			Err:        err,
+		}
+	case err != nil:
+		return err
+	}
+
+	response.SendResponse(w, http.StatusOK, &response.Response{})
+	return nil
+}
+
+// parseProfile parses the profile parameters of the request.
+func parseProfile(mp model.Profile) (dedup.Profile, error) {
+	p := dedup.Profile{
+		Name:        mp.Name,
+		Distance:    mp.Distance,
+		MinDistance: mp.MinDistance,
+		MaxDistance: mp.MaxDistance,
+	}
+	var err error
+	if mp.Window != "" {
+		if p.Window, err = dedup.ParseWindowMode(mp.Window); err != nil {
+			return p, err
+		}
+	}
+	if mp.LatenessPolicy != "" {
+		if p.LatenessPolicy, err = dedup.ParseLatenessPolicy(mp.LatenessPolicy); err != nil {
+			return p, err
+		}
+	}
+	if mp.AccuracyMode != "" {
+		if p.Accuracy, err = dedup.ParseAccuracyMode(mp.AccuracyMode); err != nil {
+			return p, err
+		}
+	}
+	if len(mp.EntityTTL) > 0 {
+		p.EntityTTL = make(map[string]time.Duration, len(mp.EntityTTL))
+		for entity, s := range mp.EntityTTL {
+			if p.EntityTTL[entity], err = time.ParseDuration(s); err != nil {
+				return p, err
+			}
+		}
+	}
+	durations := []struct {
+		s string
+		d *time.Duration
+	}{
+		{mp.TTL, &p.Interval},
+		{mp.TimeBucket, &p.Bucket},
+		{mp.KeyTTL, &p.TTL},
+		{mp.TTLGrace, &p.Grace},
+		{mp.Lateness, &p.Lateness},
+	}
+	for _, d := range durations {
+		if d.s == "" {
+			continue
+		}
+		if *d.d, err = time.ParseDuration(d.s); err != nil {
+			return p, err
+		}
+	}
+	return p, nil
+}
+
+func makeProfile(p dedup.Profile) model.Profile {
+	var entityTTL map[string]string
+	for entity, ttl := range p.EntityTTL {
+		if entityTTL == nil {
+			entityTTL = make(map[string]string, len(p.EntityTTL))
+		}
+		entityTTL[entity] = ttl.String()
+	}
+	return model.Profile{
+		Name:           p.Name,
+		Distance:       math.Round(p.Distance*100) / 100,
+		TTL:            p.Interval.String(),
+		Window:         p.Window.String(),
+		TimeBucket:     p.Bucket.String(),
+		KeyTTL:         p.TTL.String(),
+		TTLGrace:       p.Grace.String(),
+		Lateness:       p.Lateness.String(),
+		LatenessPolicy: p.LatenessPolicy.String(),
+		EntityTTL:      entityTTL,
+		AccuracyMode:   p.Accuracy.String(),
+		MinDistance:    math.Round(p.MinDistance*100) / 100,
+		MaxDistance:    math.Round(p.MaxDistance*100) / 100,
+	}
+}
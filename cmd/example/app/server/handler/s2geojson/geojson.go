@@ -2,12 +2,15 @@ package s2geojson
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 
 	"github.com/golang/geo/s2"
 )
 
 const (
 	TypePoint             Type = "Point"
+	TypeLineString        Type = "LineString"
 	TypePolygon           Type = "Polygon"
 	TypeMultiPolygon      Type = "MultiPolygon"
 	TypeFeature           Type = "Feature"
@@ -37,6 +40,25 @@ func NewPoint(lat, lng float64) Point {
 	return [2]float64{lng, lat}
 }
 
+// LineString represents GeoJSON LineString.
+type LineString [][2]float64
+
+func (l LineString) MarshalJSON() ([]byte, error) {
+	return json.Marshal(geometryObject{
+		Type:        TypeLineString,
+		Coordinates: [][2]float64(l),
+	})
+}
+
+// NewLineString returns GeoJSON LineString instance from the provided points.
+func NewLineString(points ...s2.LatLng) LineString {
+	l := make([][2]float64, 0, len(points))
+	for _, ll := range points {
+		l = append(l, [2]float64{ll.Lng.Degrees(), ll.Lat.Degrees()})
+	}
+	return l
+}
+
 // Polygon represents GeoJSON Polygon.
 type Polygon struct {
 	*s2.Loop
@@ -61,6 +83,43 @@ func (p Polygon) MarshalJSON() ([]byte, error) {
 	})
 }
 
+// UnmarshalJSON decodes GeoJSON Polygon without holes. Loop is normalised, so
+// that it contains no more than half of the sphere regardless of the winding
+// order of its ring.
+func (p *Polygon) UnmarshalJSON(b []byte) error {
+	var g struct {
+		Type        Type           `json:"type"`
+		Coordinates [][][2]float64 `json:"coordinates"`
+	}
+	if err := json.Unmarshal(b, &g); err != nil {
+		return err
+	}
+	switch {
+	case g.Type != TypePolygon:
+		return fmt.Errorf("geojson: expected %s, got %q", TypePolygon, g.Type)
+	case len(g.Coordinates) != 1:
+		return errors.New("geojson: polygon must have exactly one ring")
+	}
+	ring := g.Coordinates[0]
+	if n := len(ring); n > 1 && ring[0] == ring[n-1] {
+		ring = ring[:n-1]
+	}
+	if len(ring) < 3 {
+		return errors.New("geojson: polygon ring must have at least 3 positions")
+	}
+	pts := make([]s2.Point, 0, len(ring))
+	for _, c := range ring {
+		pts = append(pts, s2.PointFromLatLng(s2.LatLngFromDegrees(c[1], c[0])))
+	}
+	l := s2.LoopFromPoints(pts)
+	if err := l.Validate(); err != nil {
+		return fmt.Errorf("geojson: %w", err)
+	}
+	l.Normalize()
+	p.Loop = l
+	return nil
+}
+
 // MultiPolygon represents GeoJSON MultiPolygon.
 type MultiPolygon []*s2.Loop
 
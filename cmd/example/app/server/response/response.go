@@ -7,10 +7,14 @@ import (
 )
 
 const (
-	OK             Status = "OK"
-	InternalError  Status = "ERROR"
-	NotFound       Status = "NOT_FOUND"
-	InvalidRequest Status = "INVALID_REQUEST"
+	OK              Status = "OK"
+	InternalError   Status = "ERROR"
+	NotFound        Status = "NOT_FOUND"
+	InvalidRequest  Status = "INVALID_REQUEST"
+	LateEvent       Status = "LATE_EVENT"
+	FutureEvent     Status = "FUTURE_EVENT"
+	ImpossibleSpeed Status = "IMPOSSIBLE_SPEED"
+	Conflict        Status = "CONFLICT"
 )
 
 type Status string
@@ -11,13 +11,13 @@ import (
 	"github.com/roman-kulish/spatio-temporal-deduplication-example/cmd/example/app/server/handler"
 )
 
-func initRoutes(publicDir string, filter *dedup.SpatioTemporalFilter) chi.Router {
+func initRoutes(publicDir string, reg *dedup.Registry) chi.Router {
 	mux := chi.NewRouter()
 	mux.Use(
 		middleware.NoCache,
 		cors.Handler(cors.Options{
 			AllowedOrigins: []string{"*"},
-			AllowedMethods: []string{"GET", "POST", "OPTIONS"},
+			AllowedMethods: []string{"GET", "POST", "DELETE", "OPTIONS"},
 			AllowedHeaders: []string{"Accept", "Content-Type"},
 			MaxAge:         300,
 		}),
@@ -28,12 +28,43 @@ func initRoutes(publicDir string, filter *dedup.SpatioTemporalFilter) chi.Router
 	mux.MethodNotAllowed(handler.MethodNotAllowed)
 	mux.Mount("/debug", middleware.Profiler())
 
-	// public routes
-	mux.Get("/info", WithSpatioTemporalFilter(filter, handler.Info))
-	mux.Post("/grid", WithSpatioTemporalFilter(filter, handler.MapGrid))
-	mux.Get("/locations", WithSpatioTemporalFilter(filter, handler.IndexedLocations))
-	mux.Post("/locations", WithSpatioTemporalFilter(filter, handler.AddLocation))
+	// public routes of the default filter
+	def, _ := reg.Get(dedup.DefaultProfile)
+	filterRoutes(mux, func(fn HandlerFunc) http.HandlerFunc {
+		return WithSpatioTemporalFilter(def, fn)
+	})
+
+	// public routes of the filter profiles
+	mux.Get("/filters", WithRegistry(reg, handler.Profiles))
+	mux.Post("/filters", WithRegistry(reg, handler.CreateProfile))
+	mux.Route("/filters/{name}", func(r chi.Router) {
+		r.Get("/", WithProfile(reg, handler.Info))
+		r.Delete("/", WithRegistry(reg, handler.DeleteProfile))
+		filterRoutes(r, func(fn HandlerFunc) http.HandlerFunc {
+			return WithProfile(reg, fn)
+		})
+	})
+
 	mux.Method(http.MethodGet, "/*", http.FileServer(http.Dir(publicDir)))
 
 	return mux
 }
+
+// filterRoutes adds routes of the filter, which handlers are wrapped by wrap.
+func filterRoutes(r chi.Router, wrap func(HandlerFunc) http.HandlerFunc) {
+	r.Get("/info", wrap(handler.Info))
+	r.Post("/grid", wrap(handler.MapGrid))
+	r.Get("/zones", wrap(handler.Zones))
+	r.Get("/exclusions", wrap(handler.Exclusions))
+	r.Post("/exclusions", wrap(handler.PutExclusion))
+	r.Delete("/exclusions/{exclusion}", wrap(handler.DeleteExclusion))
+	r.Get("/locations", wrap(handler.IndexedLocations))
+	r.Post("/locations", wrap(handler.AddLocation))
+	r.Post("/locations/check", wrap(handler.CheckLocation))
+	r.Post("/locations/batch", wrap(handler.AddLocations))
+	r.Post("/locations/forget/region", wrap(handler.ForgetRegion))
+	r.Post("/locations/forget/time", wrap(handler.ForgetTimeRange))
+	r.Get("/dwells", wrap(handler.Dwells))
+	r.Get("/entities/{entity}/locations", wrap(handler.EntityLocations))
+	r.Delete("/entities/{entity}", wrap(handler.ForgetEntity))
+}
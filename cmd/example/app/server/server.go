@@ -1,10 +1,13 @@
 package server
 
 import (
+	"errors"
 	"net/http"
 	"os"
 	"path/filepath"
 
+	"github.com/go-chi/chi"
+
 	"github.com/roman-kulish/spatio-temporal-deduplication-example/cmd/example/app/config"
 	"github.com/roman-kulish/spatio-temporal-deduplication-example/cmd/example/app/dedup"
 	"github.com/roman-kulish/spatio-temporal-deduplication-example/cmd/example/app/server/response"
@@ -23,8 +26,42 @@ func WithSpatioTemporalFilter(f *dedup.SpatioTemporalFilter, fn HandlerFunc) htt
 	}
 }
 
+// RegistryHandlerFunc is a wrapped handler function of filter profiles.
+type RegistryHandlerFunc func(*dedup.Registry, http.ResponseWriter, *http.Request) error
+
+// WithRegistry wraps handler function into http.HandlerFunc and injects
+// dedup.Registry.
+func WithRegistry(reg *dedup.Registry, fn RegistryHandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := fn(reg, w, r); err != nil {
+			response.SendError(w, err)
+		}
+	}
+}
+
+// WithProfile wraps handler function into http.HandlerFunc and injects
+// dedup.Filter of the profile given by the "name" URL parameter.
+func WithProfile(reg *dedup.Registry, fn HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f, err := reg.Get(chi.URLParam(r, "name"))
+		if errors.Is(err, dedup.ErrProfileNotFound) {
+			err = &response.Error{
+				StatusCode: http.StatusNotFound,
+				Status:     response.NotFound,
+				Err:        err,
+			}
+		}
+		if err == nil {
+			err = fn(f, w, r)
+		}
+		if err != nil {
+			response.SendError(w, err)
+		}
+	}
+}
+
 // New creates, configures and returns an instance of http.Server.
-func New(cfg *config.Server, filter *dedup.SpatioTemporalFilter) (*http.Server, error) {
+func New(cfg *config.Server, reg *dedup.Registry) (*http.Server, error) {
 	dir, err := os.Getwd()
 	if err != nil {
 		return nil, err
@@ -38,6 +75,6 @@ func New(cfg *config.Server, filter *dedup.SpatioTemporalFilter) (*http.Server,
 		WriteTimeout:      cfg.WriteTimeout,
 		IdleTimeout:       cfg.IdleTimeout,
 	}
-	s.Handler = initRoutes(dir, filter)
+	s.Handler = initRoutes(dir, reg)
 	return &s, nil
 }
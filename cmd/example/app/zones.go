@@ -0,0 +1,86 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/golang/geo/s2"
+
+	"github.com/roman-kulish/spatio-temporal-deduplication-example/cmd/example/app/dedup"
+	"github.com/roman-kulish/spatio-temporal-deduplication-example/cmd/example/app/server/handler/s2geojson"
+)
+
+// loadZones loads tolerance zones from GeoJSON FeatureCollection of polygons.
+// Distance tolerance in meters and time tolerance in Go duration format are
+// given by "distance" and "interval" properties of the features.
+func loadZones(path string) ([]dedup.Zone, error) {
+	p, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var fc struct {
+		Features []struct {
+			Properties struct {
+				Name     string  `json:"name"`
+				Distance float64 `json:"distance"`
+				Interval string  `json:"interval"`
+			} `json:"properties"`
+			Geometry s2geojson.Polygon `json:"geometry"`
+		} `json:"features"`
+	}
+	if err = json.Unmarshal(p, &fc); err != nil {
+		return nil, fmt.Errorf("zones: %w", err)
+	}
+
+	zones := make([]dedup.Zone, 0, len(fc.Features))
+	for i, ft := range fc.Features {
+		interval, err := time.ParseDuration(ft.Properties.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("zones: feature %d: %w", i, err)
+		}
+		zones = append(zones, dedup.Zone{
+			Name:     ft.Properties.Name,
+			Loop:     ft.Geometry.Loop,
+			Distance: ft.Properties.Distance,
+			Interval: interval,
+		})
+	}
+	return zones, nil
+}
+
+// loadExclusions loads exclusion zones from GeoJSON FeatureCollection. Zone is
+// named by "name" property and is either a polygon or S2 cell tokens in
+// "cells" property of the feature, or both.
+func loadExclusions(path string) ([]dedup.Exclusion, error) {
+	p, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var fc struct {
+		Features []struct {
+			Properties struct {
+				Name  string   `json:"name"`
+				Cells []string `json:"cells"`
+			} `json:"properties"`
+			Geometry *s2geojson.Polygon `json:"geometry"`
+		} `json:"features"`
+	}
+	if err = json.Unmarshal(p, &fc); err != nil {
+		return nil, fmt.Errorf("exclusions: %w", err)
+	}
+
+	exclusions := make([]dedup.Exclusion, 0, len(fc.Features))
+	for _, ft := range fc.Features {
+		e := dedup.Exclusion{Name: ft.Properties.Name}
+		if ft.Geometry != nil {
+			e.Loop = ft.Geometry.Loop
+		}
+		for _, token := range ft.Properties.Cells {
+			e.Cells = append(e.Cells, s2.CellIDFromToken(token))
+		}
+		exclusions = append(exclusions, e)
+	}
+	return exclusions, nil
+}